@@ -16,12 +16,14 @@ package main
 
 import (
 	"../../pkg/encryption"
+	"../../pkg/keys"
 	"errors"
 	"flag"
 	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"github.com/google/tink/go/signature"
 	"io/ioutil"
 	"log"
-	"os"
+	"strconv"
 	"strings"
 )
 
@@ -45,6 +47,27 @@ func (m *mapFlags) Set(value string) error {
 	return nil
 }
 
+// Like mapFlags, but a key may be given several times.
+type multiMapFlags map[string][]string
+
+func (m *multiMapFlags) String() string {
+	var strs []string
+	for key, vals := range *m {
+		for _, val := range vals {
+			strs = append(strs, key+","+val)
+		}
+	}
+	return strings.Join(strs, "\n")
+}
+func (m *multiMapFlags) Set(value string) error {
+	s := strings.Split(value, ",")
+	if len(s) != 2 {
+		return errors.New("Malformed value inserted: " + value)
+	}
+	(*m)[s[0]] = append((*m)[s[0]], s[1])
+	return nil
+}
+
 type arrayFlags []string
 
 func (i *arrayFlags) String() string {
@@ -56,6 +79,25 @@ func (i *arrayFlags) Set(value string) error {
 	return nil
 }
 
+// Creates the recipient wrapping the document key for the domain in the
+// input format.
+func newRecipient(domain string, ks tinkpb.Keyset, format string) (encryption.Recipient, error) {
+	switch format {
+	case "", "tink":
+		return encryption.NewTinkHybridRecipient(domain, ks)
+	case "jwe":
+		if domain == "google.com" {
+			return nil, errors.New("google.com only reads Tink encrypted keys.")
+		}
+		pub, err := keys.ECIESPublicKey(&ks, ks.PrimaryKeyId)
+		if err != nil {
+			return nil, err
+		}
+		return encryption.NewJWERecipient(domain, pub, strconv.FormatUint(uint64(ks.PrimaryKeyId), 10))
+	}
+	return nil, errors.New("Unknown key format: " + format)
+}
+
 // Script to encrypt documents for the SwG Encryption Project.
 func main() {
 	// Input flags.
@@ -63,13 +105,66 @@ func main() {
 	outFile := flag.String("output_file", "", "Output path to write encrypted HTML file.")
 	var accessRequirements arrayFlags
 	flag.Var(&accessRequirements, "access_requirement", "The access requirements we grant upon decryption.")
-	mf := make(mapFlags)
+	mf := make(multiMapFlags)
 	flag.Var(&mf, "encryption_key_url", `Strings in the form of '<domain-name>,<url>', where url is 
 										 link to the hosted public key that we use to encrypt the 
 										 document key. Note that you must provide one public key for a
 										 "local" domain name. In addition, if a public key url is not 
 										 provided for the "google.com" domain name, we will add the 
-										 dev public key url to the document automatically.`)
+										 dev public key url to the document automatically. A domain
+										 may be given several times during a key rotation, and the
+										 document key is then wrapped for each of its keys.`)
+	formats := make(mapFlags)
+	flag.Var(&formats, "key_format", `Strings in the form of '<domain-name>,<format>', where format
+										 is "tink" (the default) or "jwe". JWE entries are compact
+										 ECDH-ES+A128KW / A128GCM JWE for the EC key of the domain's
+										 primary public key.`)
+	cryptoKeysVersion := flag.Int("cryptokeys_version", encryption.CryptoKeysV1, "Version of the cryptokeys script: 1 for the bare domain to key object, 2 for the versioned envelope with key IDs.")
+	mergeCryptoKeys := flag.Bool("merge_cryptokeys", false, `Merge into a cryptokeys script already in the input document instead of
+										 failing. Entries of other domains are only kept if infilePrivate
+										 unwraps their document key.`)
+	encryptedSections := flag.String("encrypted_sections", "fail", `Handling of sections that are already encrypted: "fail", "skip"
+										 to leave them as they are, or "reencrypt" to encrypt their
+										 content again under a new document key, which requires
+										 infilePrivate.`)
+	inFilePrivate := flag.String("infilePrivate", "", "Encrypted private keyset file unwrapping the existing document key for reencrypt and merge_cryptokeys.")
+	reencryptDomain := flag.String("reencrypt_domain", "local", "Domain of the existing cryptokeys entry that infilePrivate unwraps.")
+	backendName := flag.String("backend", "", "Key backend wrapping the private key: "+strings.Join(keys.Backends(), ", ")+".")
+	keyURI := flag.String("key_uri", "", "Master key URI of the private keyset. Selects the backend by its scheme instead of --backend.")
+	for _, name := range keys.Backends() {
+		b, err := keys.GetBackend(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b.AddFlags(flag.CommandLine)
+	}
+	compression := flag.String("compression", "", `Compression of the section content before encryption: "deflate"
+										 or "gzip". Requires cryptokeys_version 2.`)
+	padding := flag.String("padding", "", `Padding of the section content hiding its length: "pow2" for
+										 the next power of two, or "quantum" for the next multiple of
+										 padding_quantum bytes. Requires cryptokeys_version 2.`)
+	paddingQuantum := flag.Int("padding_quantum", 4096, "Bucket size in bytes of quantum padding.")
+	segmentSize := flag.Int("segment_size", 0, `Encrypt sections in segments of this many bytes so readers can
+										 decrypt them as a stream. Requires cryptokeys_version 2.`)
+	media := flag.String("media", "", `Protection of the local and data URI images of encrypted
+										 sections: "inline" to embed them as data URIs in the encrypted
+										 content, or "blobs" to encrypt them as separate blobs.`)
+	mediaDir := flag.String("media_dir", "", "Directory that local image sources are resolved in, as the site root.")
+	maxMediaSize := flag.Int64("max_media_size", 0, "Largest image read, in bytes. Defaults to 8 MiB.")
+	ciphertextDir := flag.String("ciphertext_dir", "", `Directory to write the ciphertext of each section to as a
+										 separate file, leaving a reference in the section.`)
+	ciphertextURLPrefix := flag.String("ciphertext_url_prefix", "", "URL prefix that the files of ciphertext_dir are served under.")
+	teaserWords := flag.Int("teaser_words", 0, "Number of words of each section to keep as a teaser.")
+	teaserFirstParagraph := flag.Bool("teaser_first_paragraph", false, "Keep the first paragraph of each section as a teaser.")
+	teaserTemplateFile := flag.String("teaser_template_file", "", `HTML template file of the teaser, executed with the teaser text
+										 as {{.Teaser}}.`)
+	signingKey := flag.String("signing_key", "", `Encrypted private signing keyset file, generated by keygen's
+										 generate-signing-key, to sign the encrypted document with.`)
+	leaks := flag.String("leaks", "", `Scan of the encrypted document for the plaintext of its sections:
+										 "report" to log the leaks or "fail" to reject the document.`)
+	redactLDJSON := flag.Bool("redact_ld_json", false, "Remove leaking values from application/ld+json scripts.")
+	leakShingleSize := flag.Int("leak_shingle_size", encryption.DefaultLeakShingleSize, "Number of words per shingle of the leak scan.")
+	leakThreshold := flag.Float64("leak_threshold", encryption.DefaultLeakThreshold, "Fraction of the shingles of a text found in the plaintext above which it leaks.")
 	flag.Parse()
 	if *inputHTMLFile == "" {
 		log.Fatal("Missing flag: input_html_file")
@@ -86,31 +181,155 @@ func main() {
 		log.Fatal(err)
 	}
 	// Retrieve all public keys from the input URLs.
-	pubKeys := make(map[string]tinkpb.Keyset)
+	pubKeys := make(map[string][]tinkpb.Keyset)
 	var pubKey tinkpb.Keyset
 	if _, ok := mf["local"]; !ok {
 		log.Fatal("'local' public key URL must be provided.")
 	}
 	if _, ok := mf["google.com"]; !ok {
-		mf["google.com"] = googleDevPublicKeyURL
+		mf["google.com"] = []string{googleDevPublicKeyURL}
+	}
+	for domain, urls := range mf {
+		for _, url := range urls {
+			pubKey, err = encryption.RetrieveTinkPublicKey(url)
+			if err != nil {
+				log.Fatal(err)
+			}
+			pubKeys[strings.ToLower(domain)] = append(pubKeys[strings.ToLower(domain)], pubKey)
+		}
+	}
+	// Wrap the document key for every domain in the requested format.
+	domainFormats := make(map[string]string)
+	for domain, format := range formats {
+		if _, ok := pubKeys[strings.ToLower(domain)]; !ok {
+			log.Fatal("No public key URL for key_format domain: " + domain)
+		}
+		domainFormats[strings.ToLower(domain)] = strings.ToLower(format)
+	}
+	var recipients []encryption.Recipient
+	for domain, keysets := range pubKeys {
+		for _, ks := range keysets {
+			r, err := newRecipient(domain, ks, domainFormats[domain])
+			if err != nil {
+				log.Fatal(err)
+			}
+			recipients = append(recipients, r)
+		}
 	}
-	for domain, url := range mf {
-		pubKey, err = encryption.RetrieveTinkPublicKey(url)
+	// Generate the encrypted document from the input HTML document.
+	opts := encryption.EncryptOptions{
+		CryptoKeysVersion: *cryptoKeysVersion,
+		MergeCryptoKeys:   *mergeCryptoKeys,
+	}
+	switch *compression {
+	case "":
+	case "deflate":
+		opts.Content.Zip = encryption.ZipDeflate
+	case "gzip":
+		opts.Content.Zip = encryption.ZipGzip
+	default:
+		log.Fatal("Unknown compression: " + *compression)
+	}
+	opts.Content.SegmentSize = *segmentSize
+	if *ciphertextDir != "" {
+		opts.External = &encryption.CiphertextDir{Dir: *ciphertextDir, URLPrefix: *ciphertextURLPrefix}
+	}
+	opts.Teaser.Words = *teaserWords
+	opts.Teaser.FirstParagraph = *teaserFirstParagraph
+	if *teaserTemplateFile != "" {
+		t, err := ioutil.ReadFile(*teaserTemplateFile)
 		if err != nil {
 			log.Fatal(err)
 		}
-		pubKeys[strings.ToLower(domain)] = pubKey
+		opts.Teaser.Template = string(t)
 	}
-	// Generate the encrypted document from the input HTML document.
-	encryptedDoc, err := encryption.GenerateEncryptedDocument(string(b), []string(accessRequirements), pubKeys)
+	opts.Media.Dir = *mediaDir
+	opts.Media.MaxSize = *maxMediaSize
+	switch *media {
+	case "":
+	case "inline":
+		opts.Media.Mode = encryption.MediaInline
+	case "blobs":
+		opts.Media.Mode = encryption.MediaBlobs
+	default:
+		log.Fatal("Unknown media: " + *media)
+	}
+	switch *padding {
+	case "":
+	case encryption.PadPowerOfTwo:
+		opts.Content.Pad = encryption.PadPowerOfTwo
+	case encryption.PadQuantum:
+		opts.Content.Pad = encryption.PadQuantum
+		opts.Content.PadQuantum = *paddingQuantum
+	default:
+		log.Fatal("Unknown padding: " + *padding)
+	}
+	uri := *keyURI
+	if uri == "" && *backendName != "" {
+		if _, uri, err = keys.ResolveBackend(*backendName, ""); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *inFilePrivate != "" {
+		privKh, _, err := keys.LoadPrivateKeyset(*inFilePrivate, uri)
+		if err != nil {
+			log.Fatal(err)
+		}
+		u, err := encryption.NewTinkHybridUnwrapper(privKh)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Unwrapper = u
+		opts.UnwrapDomain = *reencryptDomain
+	}
+	switch *encryptedSections {
+	case "fail":
+		opts.EncryptedSections = encryption.EncryptedSectionsFail
+	case "skip":
+		opts.EncryptedSections = encryption.EncryptedSectionsSkip
+	case "reencrypt":
+		if *inFilePrivate == "" {
+			log.Fatal("Missing flag: infilePrivate")
+		}
+		opts.EncryptedSections = encryption.EncryptedSectionsReencrypt
+	default:
+		log.Fatal("Unknown encrypted_sections value: " + *encryptedSections)
+	}
+	opts.Leaks.ShingleSize = *leakShingleSize
+	opts.Leaks.Threshold = *leakThreshold
+	opts.Leaks.RedactLDJSON = *redactLDJSON
+	switch *leaks {
+	case "", "report":
+	case "fail":
+		opts.Leaks.Fail = true
+	default:
+		log.Fatal("Unknown leaks value: " + *leaks)
+	}
+	if *leaks != "" || *redactLDJSON {
+		opts.Leaks.Report = func(l encryption.Leak) {
+			if l.Redacted {
+				log.Printf("Redacted plaintext leak in %s (score %.2f): %s", l.Location, l.Score, l.Text)
+			} else {
+				log.Printf("Plaintext leak in %s (score %.2f): %s", l.Location, l.Score, l.Text)
+			}
+		}
+	}
+	if *signingKey != "" {
+		signingKh, _, err := keys.LoadPrivateKeyset(*signingKey, uri)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if opts.Signer, err = signature.NewSigner(signingKh); err != nil {
+			log.Fatal(err)
+		}
+	}
+	encryptedDoc, err := encryption.GenerateEncryptedDocumentWithOptions(string(b), []string(accessRequirements), recipients, opts)
 	if err != nil {
 		log.Fatal(err)
 	}
 	// Write the encrypted document to the output file.
-	f, err := os.Create(*outFile)
-	if err != nil {
+	if err := keys.WriteKeyFile(*outFile, []byte(encryptedDoc), keys.PublicKeyFileMode, true); err != nil {
 		log.Fatal(err)
 	}
-	f.WriteString(encryptedDoc)
 	log.Println("Encrypted HTML file generated successfully")
 }
@@ -0,0 +1,95 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"../../pkg/encryption"
+	"../../pkg/keys"
+	"flag"
+	"fmt"
+	"github.com/google/tink/go/keyset"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"log"
+	"os"
+	"strings"
+)
+
+// Script to print the keys of a public or private keyset for the SwG
+// Encryption Project. Secret key material is never printed.
+func main() {
+	publicKey := flag.String("public_key", "", "URL or file of a public keyset in Tink JSON format.")
+	inFilePrivate := flag.String("infilePrivate", "", "Encrypted private keyset file.")
+	backendName := flag.String("backend", "", "Key backend wrapping the private key: "+strings.Join(keys.Backends(), ", ")+".")
+	keyURI := flag.String("key_uri", "", "Master key URI of the private keyset. Selects the backend by its scheme instead of --backend.")
+	exportFormat := flag.String("export", "", "Print the public keys in this format instead of describing them: "+keys.PublicKeyFormatPEM+", "+keys.PublicKeyFormatJWK+" or "+keys.PublicKeyFormatTink+".")
+	for _, name := range keys.Backends() {
+		b, err := keys.GetBackend(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b.AddFlags(flag.CommandLine)
+	}
+	flag.Parse()
+	if (*publicKey == "") == (*inFilePrivate == "") {
+		log.Fatal("Exactly one of public_key and infilePrivate must be given.")
+	}
+	var pubKeyset *tinkpb.Keyset
+	var header string
+	if *publicKey != "" {
+		ks, err := encryption.LoadTinkPublicKey(*publicKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pubKeyset = &ks
+	} else {
+		uri := *keyURI
+		if uri == "" && *backendName != "" {
+			var err error
+			if _, uri, err = keys.ResolveBackend(*backendName, ""); err != nil {
+				log.Fatal(err)
+			}
+		}
+		kh, f, err := keys.LoadPrivateKeyset(*inFilePrivate, uri)
+		if err != nil {
+			log.Fatal(err)
+		}
+		header = fmt.Sprintf("File format:    %s\nMaster key URI: %s\n", f.Format, f.KMSURI)
+		if !f.Created.IsZero() {
+			header += fmt.Sprintf("Created:        %s\n", f.Created)
+		}
+		pub, err := kh.Public()
+		if err != nil {
+			log.Fatal(err)
+		}
+		mem := &keyset.MemReaderWriter{}
+		if err := pub.WriteWithNoSecrets(mem); err != nil {
+			log.Fatal(err)
+		}
+		pubKeyset = mem.Keyset
+	}
+	if *exportFormat != "" {
+		b, err := keys.ExportPublicKeyset(pubKeyset, *exportFormat)
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(b)
+		return
+	}
+	report, err := keys.InspectPublicKeyset(pubKeyset)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(header + report.String())
+}
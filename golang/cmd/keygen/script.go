@@ -0,0 +1,356 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"../../pkg/keys"
+	"flag"
+	"fmt"
+	"github.com/google/tink/go/keyset"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Flags shared by all subcommands.
+type commonFlags struct {
+	backendName *string
+	keyURI      *string
+	format      *string
+	force       *bool
+}
+
+// Creates a FlagSet for the named subcommand with the common and backend
+// specific flags registered.
+func newFlagSet(name string) (*flag.FlagSet, *commonFlags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	c := &commonFlags{
+		backendName: fs.String("backend", "", "Key backend wrapping the private key: "+strings.Join(keys.Backends(), ", ")+"."),
+		keyURI:      fs.String("key_uri", "", "Master key URI. Selects the backend by its scheme instead of the backend flags."),
+		format:      fs.String("private_format", keys.PrivateKeysetFormatJSON, "Format of the written private keyset: json or binary."),
+		force:       fs.Bool("force", false, "Overwrite existing output files."),
+	}
+	for _, name := range keys.Backends() {
+		b, err := keys.GetBackend(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b.AddFlags(fs)
+	}
+	return fs, c
+}
+
+// Registers the flags selecting the template of new keys.
+func templateFlags(fs *flag.FlagSet) (*string, *string) {
+	template := fs.String("template", keys.DefaultKeyTemplate, "Key template of the new key: "+strings.Join(keys.KeyTemplateNames(), ", ")+".")
+	pointFormat := fs.String("point_format", keys.PointFormatUncompressed, "EC point format of the new key: "+keys.PointFormatUncompressed+" or "+keys.PointFormatCompressed+".")
+	return template, pointFormat
+}
+
+// Script to create and manage Tink Hybrid keys using envelope encryption.
+func main() {
+	cmd, args := "generate", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "generate":
+		generate(args)
+	case "generate-signing-key":
+		generateSigningKey(args)
+	case "migrate":
+		migrate(args)
+	case "rotate":
+		rotate(args)
+	case "promote", "disable", "enable", "destroy":
+		changeKey(cmd, args)
+	case "export":
+		export(args)
+	case "import":
+		importKey(args)
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: keygen [generate|generate-signing-key|migrate|rotate|promote|disable|enable|destroy|export|import] [flags]")
+		os.Exit(2)
+	}
+}
+
+// Generates a new key pair.
+func generate(args []string) {
+	fs, c := newFlagSet("generate")
+	template, pointFormat := templateFlags(fs)
+	outFilePrivate := fs.String("outfilePrivate", "", "Output file for private key.")
+	outFilePublic := fs.String("outfilePublic", "", "Output file for public key.")
+	fs.Parse(args)
+	kt, err := keys.KeyTemplate(*template, *pointFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create a Tink Hybrid key handle to encrypt document keys.
+	generateKeyset(c, kt, *outFilePrivate, *outFilePublic)
+}
+
+// Generates a new key pair signing encrypted documents.
+func generateSigningKey(args []string) {
+	fs, c := newFlagSet("generate-signing-key")
+	template := fs.String("template", keys.DefaultSigningKeyTemplate, "Key template of the new signing key: "+strings.Join(keys.SigningKeyTemplateNames(), ", ")+".")
+	outFilePrivate := fs.String("outfilePrivate", "", "Output file for private key.")
+	outFilePublic := fs.String("outfilePublic", "", "Output file for public key.")
+	fs.Parse(args)
+	kt, err := keys.SigningKeyTemplate(*template)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create a Tink Signature key handle to sign documents.
+	generateKeyset(c, kt, *outFilePrivate, *outFilePublic)
+}
+
+// Generates a keyset from the template and writes its private keyset through
+// the master key and its public keyset to the output files.
+func generateKeyset(c *commonFlags, kt *tinkpb.KeyTemplate, outFilePrivate string, outFilePublic string) {
+	if err := keys.ValidateOutputPaths(outFilePrivate, outFilePublic); err != nil {
+		log.Fatal(err)
+	}
+	if err := keys.CheckOverwrite(*c.force, outFilePrivate, outFilePublic); err != nil {
+		log.Fatal(err)
+	}
+	backend, uri, err := keys.ResolveBackend(*c.backendName, *c.keyURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create an AEAD that uses the master key.
+	masterKey, err := backend.MasterKey(uri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if masterKey == nil {
+		log.Println("WARNING: the private keyset is written in cleartext.")
+	}
+
+	kh, err := keys.GenerateKeyset(kt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Write the encrypted Tink private key to the output file.
+	if err := keys.WritePrivateKeyset(kh, masterKey, uri, *c.format, outFilePrivate, *c.force); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Private keyset written to file: ", outFilePrivate)
+
+	// Write the public key to the output file.
+	if err := keys.WritePublicKeyset(kh, outFilePublic, *c.force); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Public keyset written to file: ", outFilePublic)
+}
+
+// Rewrites a private keyset file, such as one written by the old key
+// generators, in the current format.
+func migrate(args []string) {
+	fs, c := newFlagSet("migrate")
+	inFilePrivate := fs.String("infilePrivate", "", "Private key file to migrate.")
+	outFilePrivate := fs.String("outfilePrivate", "", "Output file for the migrated private key.")
+	fs.Parse(args)
+	if *inFilePrivate == "" || *outFilePrivate == "" {
+		log.Fatal("Missing flag: infilePrivate and outfilePrivate are required.")
+	}
+	if err := keys.CheckOverwrite(*c.force, *outFilePrivate); err != nil {
+		log.Fatal(err)
+	}
+	kh, f := loadPrivateKeyset(c, *inFilePrivate)
+	if err := f.Rewrite(kh, *c.format, *outFilePrivate, *c.force); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Private keyset written to file: ", *outFilePrivate)
+}
+
+// Adds a new key to an existing private keyset and exports the public keyset
+// holding both the old and the new key.
+func rotate(args []string) {
+	fs, c := newFlagSet("rotate")
+	template, pointFormat := templateFlags(fs)
+	inFilePrivate := fs.String("infilePrivate", "", "Private key file to rotate.")
+	outFilePrivate := fs.String("outfilePrivate", "", "Output file for the rotated private key. Defaults to infilePrivate.")
+	outFilePublic := fs.String("outfilePublic", "", "Output file for public key.")
+	promote := fs.Bool("promote", false, "Make the new key primary right away instead of staging it.")
+	fs.Parse(args)
+	if *outFilePrivate == "" {
+		*outFilePrivate = *inFilePrivate
+	}
+	if *inFilePrivate == "" {
+		log.Fatal("Missing flag: infilePrivate")
+	}
+	if err := keys.ValidateOutputPaths(*outFilePrivate, *outFilePublic); err != nil {
+		log.Fatal(err)
+	}
+	kt, err := keys.KeyTemplate(*template, *pointFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	orig, f := loadPrivateKeyset(c, *inFilePrivate)
+	kh, keyID, err := keys.AddKey(orig, kt, *promote)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *promote {
+		log.Println("Added primary key", keyID)
+	} else {
+		log.Println("Added staged key", keyID, "- make it primary with: keygen promote --key_id", keyID)
+	}
+	writeKeysets(kh, orig, f, c, *inFilePrivate, *outFilePrivate, *outFilePublic)
+}
+
+// Promotes, disables, enables or destroys a single key of a private keyset.
+func changeKey(cmd string, args []string) {
+	fs, c := newFlagSet(cmd)
+	inFilePrivate := fs.String("infilePrivate", "", "Private key file to change.")
+	outFilePrivate := fs.String("outfilePrivate", "", "Output file for the changed private key. Defaults to infilePrivate.")
+	outFilePublic := fs.String("outfilePublic", "", "Output file for public key. The public key is not written if empty.")
+	keyID := fs.Uint("key_id", 0, "ID of the key to change.")
+	fs.Parse(args)
+	if *outFilePrivate == "" {
+		*outFilePrivate = *inFilePrivate
+	}
+	if *inFilePrivate == "" || *keyID == 0 {
+		log.Fatal("Missing flag: infilePrivate and key_id are required.")
+	}
+	orig, f := loadPrivateKeyset(c, *inFilePrivate)
+	var kh *keyset.Handle
+	var err error
+	switch cmd {
+	case "promote":
+		kh, err = keys.PromoteKey(orig, uint32(*keyID))
+	case "disable":
+		kh, err = keys.DisableKey(orig, uint32(*keyID))
+	case "enable":
+		kh, err = keys.EnableKey(orig, uint32(*keyID))
+	case "destroy":
+		kh, err = keys.DestroyKey(orig, uint32(*keyID))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Key", *keyID, "changed:", cmd)
+	writeKeysets(kh, orig, f, c, *inFilePrivate, *outFilePrivate, *outFilePublic)
+}
+
+// Exports the public keyset of a private keyset file for tools that do not
+// use Tink.
+func export(args []string) {
+	fs, c := newFlagSet("export")
+	inFilePrivate := fs.String("infilePrivate", "", "Private key file to export the public key of.")
+	outFile := fs.String("outfile", "", "Output file for the exported public key.")
+	format := fs.String("public_format", keys.PublicKeyFormatPEM, "Export format: "+keys.PublicKeyFormatPEM+", "+keys.PublicKeyFormatJWK+" or "+keys.PublicKeyFormatTink+".")
+	fs.Parse(args)
+	if *inFilePrivate == "" || *outFile == "" {
+		log.Fatal("Missing flag: infilePrivate and outfile are required.")
+	}
+	kh, _ := loadPrivateKeyset(c, *inFilePrivate)
+	pub, err := kh.Public()
+	if err != nil {
+		log.Fatal(err)
+	}
+	mem := &keyset.MemReaderWriter{}
+	if err := pub.WriteWithNoSecrets(mem); err != nil {
+		log.Fatal(err)
+	}
+	b, err := keys.ExportPublicKeyset(mem.Keyset, *format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := keys.WriteKeyFile(*outFile, b, keys.PublicKeyFileMode, *c.force); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Public key written to file: ", *outFile)
+}
+
+// Imports an external EC public key into a Tink public keyset for the
+// encrypt script.
+func importKey(args []string) {
+	fs, c := newFlagSet("import")
+	template, pointFormat := templateFlags(fs)
+	inFile := fs.String("infile", "", "PEM or JWK file holding the EC public key.")
+	outFilePublic := fs.String("outfilePublic", "", "Output file for the Tink public keyset.")
+	fs.Parse(args)
+	if *inFile == "" || *outFilePublic == "" {
+		log.Fatal("Missing flag: infile and outfilePublic are required.")
+	}
+	b, err := ioutil.ReadFile(*inFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ks, err := keys.ImportPublicKey(b, *template, *pointFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := keys.ExportPublicKeyset(ks, keys.PublicKeyFormatTink)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := keys.WriteKeyFile(*outFilePublic, out, keys.PublicKeyFileMode, *c.force); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Imported key", ks.PrimaryKeyId, "written to file: ", *outFilePublic)
+}
+
+// Loads a private keyset file using the master key selected by the flags.
+func loadPrivateKeyset(c *commonFlags, path string) (*keyset.Handle, *keys.PrivateKeysetFile) {
+	uri := *c.keyURI
+	if uri == "" && *c.backendName != "" {
+		var err error
+		if _, uri, err = keys.ResolveBackend(*c.backendName, ""); err != nil {
+			log.Fatal(err)
+		}
+	}
+	kh, f, err := keys.LoadPrivateKeyset(path, uri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Read", f.Format, "private keyset from file:", path)
+	return kh, f
+}
+
+// Writes the private keyset back through its master key, and the public
+// keyset if a path is given. The input private keyset file and the public
+// keyset of the original keyset orig are replaced in place; other existing
+// files are only replaced with --force.
+func writeKeysets(kh *keyset.Handle, orig *keyset.Handle, f *keys.PrivateKeysetFile, c *commonFlags, inPath string, privatePath string, publicPath string) {
+	overwrite := *c.force || filepath.Clean(privatePath) == filepath.Clean(inPath)
+	overwritePublic := *c.force || keys.IsPublicKeysetOf(publicPath, orig)
+	// Check both paths before the private keyset is changed.
+	if err := keys.CheckOverwrite(overwrite, privatePath); err != nil {
+		log.Fatal(err)
+	}
+	if err := keys.CheckOverwrite(overwritePublic, publicPath); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Rewrite(kh, *c.format, privatePath, overwrite); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Private keyset written to file: ", privatePath)
+	if publicPath == "" {
+		return
+	}
+	if err := keys.WritePublicKeyset(kh, publicPath, overwritePublic); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Public keyset written to file: ", publicPath)
+}
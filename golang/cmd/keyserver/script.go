@@ -0,0 +1,57 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"../../pkg/keyserver"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type arrayFlags []string
+
+func (i *arrayFlags) String() string {
+	return strings.Join(*i, ", ")
+}
+
+func (i *arrayFlags) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Script to host Tink public keys for the SwG Encryption Project.
+func main() {
+	addr := flag.String("addr", ":8080", "Address to listen on.")
+	path := flag.String("path", "/publickey", "URL path the public keyset is served at.")
+	maxAge := flag.Duration("max_age", time.Hour, "How long clients may cache the public keyset.")
+	var publicKeyFiles arrayFlags
+	flag.Var(&publicKeyFiles, "public_key_file", `Public keyset JSON file to serve. Repeat the flag to 
+										 serve several keys during a rotation; the primary key of 
+										 the first file is served as the primary key.`)
+	flag.Parse()
+	if len(publicKeyFiles) == 0 {
+		log.Fatal("Missing flag: public_key_file")
+	}
+	h, err := keyserver.NewHandler([]string(publicKeyFiles), *maxAge)
+	if err != nil {
+		log.Fatal(err)
+	}
+	http.Handle(*path, h)
+	log.Println("Serving public keyset at ", *addr+*path)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
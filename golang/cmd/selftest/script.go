@@ -0,0 +1,74 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"../../pkg/encryption"
+	"../../pkg/keys"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Script to check that a published public key matches its private key for
+// the SwG Encryption Project.
+func main() {
+	publicKey := flag.String("public_key", "", "URL or file of the published public keyset in Tink JSON format.")
+	inFilePrivate := flag.String("infilePrivate", "", "Encrypted private keyset file.")
+	backendName := flag.String("backend", "", "Key backend wrapping the private key: "+strings.Join(keys.Backends(), ", ")+".")
+	keyURI := flag.String("key_uri", "", "Master key URI of the private keyset. Selects the backend by its scheme instead of --backend.")
+	for _, name := range keys.Backends() {
+		b, err := keys.GetBackend(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b.AddFlags(flag.CommandLine)
+	}
+	flag.Parse()
+	if *publicKey == "" {
+		log.Fatal("Missing flag: public_key")
+	}
+	if *inFilePrivate == "" {
+		log.Fatal("Missing flag: infilePrivate")
+	}
+	pubKey, err := encryption.LoadTinkPublicKey(*publicKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	uri := *keyURI
+	if uri == "" && *backendName != "" {
+		if _, uri, err = keys.ResolveBackend(*backendName, ""); err != nil {
+			log.Fatal(err)
+		}
+	}
+	privKh, _, err := keys.LoadPrivateKeyset(*inFilePrivate, uri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r, err := encryption.VerifyKeyPair(pubKey, privKh)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Public primary key ID: %d\n", r.PublicPrimaryKeyID)
+	fmt.Printf("Public key IDs:        %v\n", r.PublicKeyIDs)
+	fmt.Printf("Private key IDs:       %v\n", r.PrivateKeyIDs)
+	if !r.Passed {
+		fmt.Println("FAIL:", r.Reason)
+		os.Exit(1)
+	}
+	fmt.Println("PASS")
+}
@@ -0,0 +1,87 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+)
+
+// Helper functions to compress section plaintext before encryption.
+
+// Compression algorithms of the section plaintext, recorded as "zip" in
+// version 2 cryptokeys scripts. ZipDeflate is raw DEFLATE (RFC 1951) as in
+// JWE, ZipGzip is gzip (RFC 1952).
+const (
+	ZipDeflate string = "DEF"
+	ZipGzip    string = "gzip"
+)
+
+// Largest inflated section accepted by the decryptor, guarding against
+// decompression bombs.
+const maxInflatedSectionSize int64 = 16 << 20
+
+// Creates a writer compressing to w with the input algorithm, or passes
+// writes through if the algorithm is empty.
+func newCompressWriter(w io.Writer, zip string) (io.WriteCloser, error) {
+	switch zip {
+	case "":
+		return nopWriteCloser{w}, nil
+	case ZipDeflate:
+		return flate.NewWriter(w, flate.BestCompression)
+	case ZipGzip:
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	}
+	return nil, errors.New("Unsupported compression: " + zip)
+}
+
+// Creates a reader inflating the content read from r with the input
+// algorithm, or returns r if the algorithm is empty. Reads fail once more
+// than maxInflatedSectionSize bytes are inflated.
+func newInflateReader(r io.Reader, zip string) (io.Reader, error) {
+	switch zip {
+	case "":
+		return r, nil
+	case ZipDeflate:
+		return &sizeLimitReader{r: flate.NewReader(r), left: maxInflatedSectionSize}, nil
+	case ZipGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &sizeLimitReader{r: gr, left: maxInflatedSectionSize}, nil
+	}
+	return nil, errors.New("Unsupported compression: " + zip)
+}
+
+// Fails reads past a size limit, unlike io.LimitReader which truncates.
+type sizeLimitReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return 0, errors.New("Inflated section exceeds the size limit.")
+	}
+	return n, err
+}
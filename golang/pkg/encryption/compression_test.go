@@ -0,0 +1,93 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// Compresses the input content through a compress writer.
+func compressTestContent(t *testing.T, content []byte, zip string) []byte {
+	var buf bytes.Buffer
+	w, err := newCompressWriter(&buf, zip)
+	if err != nil {
+		t.Fatalf("Failed to create compress writer for %q: %v", zip, err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatalf("Failed to compress with %q: %v", zip, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to compress with %q: %v", zip, err)
+	}
+	return buf.Bytes()
+}
+
+func TestCompressSectionRoundTrip(t *testing.T) {
+	content := []byte(strings.Repeat("<p>Some premium paragraph.</p>", 100))
+	for _, zip := range []string{"", ZipDeflate, ZipGzip} {
+		compressed := compressTestContent(t, content, zip)
+		if zip != "" && len(compressed) >= len(content) {
+			t.Errorf("Compression with %q did not shrink the content.", zip)
+		}
+		r, err := newInflateReader(bytes.NewReader(compressed), zip)
+		if err != nil {
+			t.Fatalf("Failed to create inflate reader for %q: %v", zip, err)
+		}
+		inflated, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Failed to inflate with %q: %v", zip, err)
+		}
+		if !bytes.Equal(inflated, content) {
+			t.Errorf("Round trip with %q changed the content.", zip)
+		}
+	}
+	if _, err := newCompressWriter(ioutil.Discard, "br"); err == nil {
+		t.Errorf("Expected failure on unsupported compression.")
+	}
+}
+
+func TestInflateSectionSizeLimit(t *testing.T) {
+	bomb := compressTestContent(t, make([]byte, maxInflatedSectionSize+1), ZipDeflate)
+	r, err := newInflateReader(bytes.NewReader(bomb), ZipDeflate)
+	if err != nil {
+		t.Fatalf("Failed to create inflate reader: %v", err)
+	}
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Errorf("Expected failure on oversized section.")
+	}
+	exact := compressTestContent(t, make([]byte, maxInflatedSectionSize), ZipDeflate)
+	r, err = newInflateReader(bytes.NewReader(exact), ZipDeflate)
+	if err != nil {
+		t.Fatalf("Failed to create inflate reader: %v", err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Errorf("Failed to inflate section at the size limit: %v", err)
+	}
+}
+
+func TestEncryptDocumentCompressedVersion1(t *testing.T) {
+	htmlStr, err := loadTestFileString("sample_encryption.html")
+	if err != nil {
+		t.Fatalf("HTML file load failed.")
+	}
+	recipients, _ := newTestRecipient(t)
+	opts := EncryptOptions{Content: ContentParams{Zip: ZipGzip}}
+	if _, err := GenerateEncryptedDocumentWithOptions(htmlStr, []string{"norcal.com:premium"}, recipients, opts); err == nil {
+		t.Errorf("Expected failure on compression with cryptokeys version 1.")
+	}
+}
@@ -0,0 +1,263 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Helper functions to write and read the cryptokeys script.
+
+// Versions of the cryptokeys script. Version 1 is a bare JSON object of
+// domain to entry. Version 2 wraps the entries in an envelope recording the
+// version, the content encryption algorithm and the key IDs.
+const (
+	CryptoKeysV1 int = 1
+	CryptoKeysV2 int = 2
+)
+
+// Content encryption algorithm of the encrypted sections.
+const contentAlgA128GCM string = "A128GCM"
+
+// A document key wrapped for one key of a domain.
+type WrappedKey struct {
+	// ID of the wrapping key, empty if the recipient has no key IDs.
+	KeyID string `json:"kid,omitempty"`
+	// Cryptokeys entry written by the Recipient.
+	Ciphertext string `json:"ct"`
+}
+
+// Processing of the section plaintext, recorded in version 2 cryptokeys
+// scripts. The zero value is the section HTML encrypted as is, the only
+// processing version 1 supports.
+type ContentParams struct {
+	// Compression applied before encryption: ZipDeflate, ZipGzip or empty.
+	Zip string
+	// Padding applied after compression: PadPowerOfTwo, PadQuantum or empty.
+	Pad string
+	// Bucket size in bytes of PadQuantum.
+	PadQuantum int
+	// Plaintext segment size in bytes of the segmented format, or zero to
+	// encrypt each section as one message.
+	SegmentSize int
+}
+
+// The parsed contents of a cryptokeys script.
+type CryptoKeys struct {
+	Version int
+	// Content encryption algorithm, implied for version 1.
+	Alg string
+	// Processing of the section plaintext.
+	Content ContentParams
+	// Wrapped document keys by domain.
+	Keys map[string][]WrappedKey
+}
+
+// Envelope of version 2 cryptokeys scripts. Each domain maps to a WrappedKey,
+// or to a list of them if the domain has several keys.
+type cryptoKeysEnvelope struct {
+	V    int                        `json:"v"`
+	Alg  string                     `json:"alg"`
+	Zip  string                     `json:"zip,omitempty"`
+	Pad  string                     `json:"pad,omitempty"`
+	PadQ int                        `json:"padq,omitempty"`
+	Seg  int                        `json:"seg,omitempty"`
+	Keys map[string]json.RawMessage `json:"keys"`
+}
+
+// Serializes the wrapped keys as the JSON of a cryptokeys script in the
+// input version, along with the processing of the section plaintext. Version
+// 1 maps every domain to its bare entry, so domains with several keys, such
+// as during a key rotation, require version 2.
+func marshalCryptoKeys(wrappedKeys map[string][]WrappedKey, version int, content ContentParams) ([]byte, error) {
+	switch version {
+	case 0, CryptoKeysV1:
+		if content != (ContentParams{}) {
+			return nil, errors.New("Processing the section plaintext requires cryptokeys version 2.")
+		}
+		out := make(map[string]string)
+		for domain, keys := range wrappedKeys {
+			if len(keys) != 1 {
+				return nil, errors.New("Several keys for domain " + domain + " require cryptokeys version 2.")
+			}
+			out[domain] = keys[0].Ciphertext
+		}
+		return json.Marshal(out)
+	case CryptoKeysV2:
+		env := cryptoKeysEnvelope{V: CryptoKeysV2, Alg: contentAlgA128GCM, Zip: content.Zip, Pad: content.Pad, PadQ: content.PadQuantum, Seg: content.SegmentSize, Keys: make(map[string]json.RawMessage)}
+		for domain, keys := range wrappedKeys {
+			var b []byte
+			var err error
+			if len(keys) == 1 {
+				b, err = json.Marshal(keys[0])
+			} else {
+				b, err = json.Marshal(keys)
+			}
+			if err != nil {
+				return nil, err
+			}
+			env.Keys[domain] = b
+		}
+		return json.Marshal(env)
+	}
+	return nil, fmt.Errorf("Unsupported cryptokeys version: %d", version)
+}
+
+// Public function to parse the contents of a cryptokeys script of any
+// version.
+func ParseCryptoKeys(cryptoKeys string) (*CryptoKeys, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(cryptoKeys), &raw); err != nil {
+		return nil, err
+	}
+	// Version 1 values are strings, so a numeric "v" marks an envelope.
+	var version int
+	if v, ok := raw["v"]; ok && json.Unmarshal(v, &version) == nil {
+		if version != CryptoKeysV2 {
+			return nil, fmt.Errorf("Unsupported cryptokeys version: %d", version)
+		}
+		var env cryptoKeysEnvelope
+		if err := json.Unmarshal([]byte(cryptoKeys), &env); err != nil {
+			return nil, err
+		}
+		if env.Alg != contentAlgA128GCM {
+			return nil, errors.New("Unsupported content encryption algorithm: " + env.Alg)
+		}
+		if env.Zip != "" && env.Zip != ZipDeflate && env.Zip != ZipGzip {
+			return nil, errors.New("Unsupported compression: " + env.Zip)
+		}
+		if env.Pad != "" && env.Pad != PadPowerOfTwo && env.Pad != PadQuantum {
+			return nil, errors.New("Unsupported padding: " + env.Pad)
+		}
+		if env.PadQ < 0 || env.PadQ > MaxPadQuantum || (env.Pad == PadQuantum && env.PadQ == 0) {
+			return nil, errors.New("Unsupported padding quantum.")
+		}
+		if env.Seg < 0 || env.Seg > MaxSegmentSize {
+			return nil, errors.New("Unsupported segment size.")
+		}
+		content := ContentParams{Zip: env.Zip, Pad: env.Pad, PadQuantum: env.PadQ, SegmentSize: env.Seg}
+		ck := &CryptoKeys{Version: env.V, Alg: env.Alg, Content: content, Keys: make(map[string][]WrappedKey)}
+		for domain, v := range env.Keys {
+			var wk WrappedKey
+			if err := json.Unmarshal(v, &wk); err == nil {
+				ck.Keys[domain] = []WrappedKey{wk}
+				continue
+			}
+			var keys []WrappedKey
+			if err := json.Unmarshal(v, &keys); err != nil {
+				return nil, errors.New("Malformed cryptokeys entry for domain " + domain + ".")
+			}
+			ck.Keys[domain] = keys
+		}
+		return ck, nil
+	}
+	ck := &CryptoKeys{Version: CryptoKeysV1, Alg: contentAlgA128GCM, Keys: make(map[string][]WrappedKey)}
+	for domain, v := range raw {
+		var ct string
+		if err := json.Unmarshal(v, &ct); err != nil {
+			return nil, errors.New("Malformed cryptokeys entry for domain " + domain + ".")
+		}
+		ck.Keys[domain] = []WrappedKey{{Ciphertext: ct}}
+	}
+	return ck, nil
+}
+
+// Merges the wrapped keys into the contents of an existing cryptokeys script
+// of any version. The new entries replace all entries of their domain. The
+// entries of other domains are kept if they wrap the same document key as
+// the new ones, and rejected otherwise, as they could not decrypt the
+// document. The existing script must have the input version and content
+// parameters, which the merged script keeps.
+func mergeCryptoKeys(existing string, wrappedKeys map[string][]WrappedKey, sharedKey bool, version int, content ContentParams) (map[string][]WrappedKey, error) {
+	ck, err := ParseCryptoKeys(existing)
+	if err != nil {
+		return nil, errors.New("Failed to parse the existing cryptokeys script: " + err.Error())
+	}
+	if version == 0 {
+		version = CryptoKeysV1
+	}
+	if ck.Version != version || ck.Content != content {
+		return nil, fmt.Errorf("The existing cryptokeys script has version %d and content parameters %+v, which conflict with version %d and %+v.", ck.Version, ck.Content, version, content)
+	}
+	merged := ck.Keys
+	for domain := range merged {
+		if _, ok := wrappedKeys[domain]; !ok && !sharedKey {
+			return nil, errors.New("The existing cryptokeys entry for domain " + domain + " wraps another document key. Unwrap its document key to keep it, or wrap the new key for the domain.")
+		}
+	}
+	for domain, keys := range wrappedKeys {
+		merged[domain] = keys
+	}
+	return merged, nil
+}
+
+// Public function to unwrap the document key of a domain from the contents of
+// a cryptokeys script of any version. Entries are only tried if the
+// unwrapper holds their key ID, so the right key is picked during a key
+// rotation.
+func UnwrapDomainDocumentKey(cryptoKeys string, domain string, u KeyUnwrapper, credential string, checker EntitlementChecker) ([]byte, error) {
+	swgKey, err := unwrapDomainSwgEncryptionKey(cryptoKeys, domain, u)
+	if err != nil {
+		return nil, err
+	}
+	return checkSwgEncryptionKey(swgKey, credential, checker)
+}
+
+// Unwraps the first cryptokeys entry of the domain that the unwrapper holds
+// the key of.
+func unwrapDomainSwgEncryptionKey(cryptoKeys string, domain string, u KeyUnwrapper) (*swgEncryptionKey, error) {
+	ck, err := ParseCryptoKeys(cryptoKeys)
+	if err != nil {
+		return nil, err
+	}
+	keys, ok := ck.Keys[domain]
+	if !ok {
+		return nil, errors.New("No document key for domain " + domain + ".")
+	}
+	var lastErr error = errors.New("No document key for domain " + domain + " matches the unwrapping key.")
+	for _, wk := range keys {
+		if wk.KeyID != "" && !u.HasKey(wk.KeyID) {
+			continue
+		}
+		swgKey, err := unwrapSwgEncryptionKey(wk.Ciphertext, u)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return swgKey, nil
+	}
+	return nil, lastErr
+}
+
+// Unwraps the document key of the existing cryptokeys script with
+// opts.Unwrapper.
+func unwrapExistingDocumentKey(cryptoKeys string, opts EncryptOptions) ([]byte, error) {
+	// The publisher holds the private key, so no entitlement check applies.
+	swgKey, err := unwrapDomainSwgEncryptionKey(cryptoKeys, opts.UnwrapDomain, opts.Unwrapper)
+	if err != nil {
+		return nil, err
+	}
+	docKey, err := base64.StdEncoding.DecodeString(swgKey.Key)
+	if err != nil {
+		return nil, err
+	}
+	if len(docKey) != int(aesGCMKeySize) {
+		return nil, errors.New("The existing document key has the wrong size.")
+	}
+	return docKey, nil
+}
@@ -0,0 +1,175 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bytes"
+	"github.com/google/tink/go/keyset"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"strconv"
+	"testing"
+)
+
+var testChecker = &StaticEntitlementChecker{Grants: map[string][]string{"reader": []string{"norcal.com:premium"}}}
+
+func TestCryptoKeysRotationOverlap(t *testing.T) {
+	oldPriv, oldPub := newTestKeyPair(t)
+	newPriv, newPub := newTestKeyPair(t)
+	recipients, err := NewTinkHybridRecipientsForKeysets(map[string][]tinkpb.Keyset{
+		"local": []tinkpb.Keyset{oldPub, newPub},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create recipients: %v", err)
+	}
+	docKey := []byte("0123456789abcdef")
+	wrapped, err := wrapDocumentKey(docKey, []string{"norcal.com:premium"}, recipients)
+	if err != nil {
+		t.Fatalf("Failed to wrap document key: %v", err)
+	}
+	if _, err := marshalCryptoKeys(wrapped, CryptoKeysV1, ContentParams{}); err == nil {
+		t.Errorf("Expected failure on several keys in version 1.")
+	}
+	b, err := marshalCryptoKeys(wrapped, CryptoKeysV2, ContentParams{})
+	if err != nil {
+		t.Fatalf("Failed to marshal cryptokeys: %v", err)
+	}
+	parsed, err := ParseCryptoKeys(string(b))
+	if err != nil {
+		t.Fatalf("Failed to parse cryptokeys: %v", err)
+	}
+	if len(parsed.Keys["local"]) != 2 || parsed.Keys["local"][1].KeyID != strconv.FormatUint(uint64(newPub.PrimaryKeyId), 10) {
+		t.Errorf("Unexpected entries: %+v", parsed.Keys["local"])
+	}
+	for _, privKh := range []*keyset.Handle{oldPriv, newPriv} {
+		u, err := NewTinkHybridUnwrapper(privKh)
+		if err != nil {
+			t.Fatalf("Failed to create unwrapper: %v", err)
+		}
+		key, err := UnwrapDomainDocumentKey(string(b), "local", u, "reader", testChecker)
+		if err != nil {
+			t.Fatalf("Failed to unwrap document key: %v", err)
+		}
+		if !bytes.Equal(key, docKey) {
+			t.Errorf("Unwrapped key %x; want %x", key, docKey)
+		}
+	}
+}
+
+func TestCryptoKeysSingleKeyUnchanged(t *testing.T) {
+	recipients, u := newTestRecipient(t)
+	docKey := []byte("0123456789abcdef")
+	wrapped, err := wrapDocumentKey(docKey, []string{"norcal.com:premium"}, recipients)
+	if err != nil {
+		t.Fatalf("Failed to wrap document key: %v", err)
+	}
+	b, err := marshalCryptoKeys(wrapped, CryptoKeysV1, ContentParams{})
+	if err != nil {
+		t.Fatalf("Failed to marshal cryptokeys: %v", err)
+	}
+	if want := `{"local":"` + wrapped["local"][0].Ciphertext + `"}`; string(b) != want {
+		t.Errorf("Got cryptokeys %s; want %s", b, want)
+	}
+	if _, err := UnwrapDomainDocumentKey(string(b), "local", u, "reader", testChecker); err != nil {
+		t.Errorf("Failed to unwrap document key: %v", err)
+	}
+	if _, err := UnwrapDomainDocumentKey(string(b), "google.com", u, "reader", testChecker); err == nil {
+		t.Errorf("Expected failure for missing domain.")
+	}
+}
+
+func TestWrapDocumentKeyDuplicateKey(t *testing.T) {
+	_, pubKs := newTestKeyPair(t)
+	recipients, err := NewTinkHybridRecipientsForKeysets(map[string][]tinkpb.Keyset{
+		"local": []tinkpb.Keyset{pubKs, pubKs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create recipients: %v", err)
+	}
+	if _, err := wrapDocumentKey([]byte("0123456789abcdef"), nil, recipients); err == nil {
+		t.Errorf("Expected failure on duplicate key.")
+	}
+}
+
+func TestParseCryptoKeysVersion2(t *testing.T) {
+	ck, err := ParseCryptoKeys(`{"v":2,"alg":"A128GCM","keys":{"local":{"kid":"1","ct":"a"},"rotating.com":[{"kid":"2","ct":"b"},{"kid":"3","ct":"c"}]}}`)
+	if err != nil {
+		t.Fatalf("Failed to parse cryptokeys: %v", err)
+	}
+	if ck.Version != CryptoKeysV2 || ck.Alg != "A128GCM" {
+		t.Errorf("Unexpected envelope: %+v", ck)
+	}
+	if len(ck.Keys["local"]) != 1 || ck.Keys["local"][0] != (WrappedKey{KeyID: "1", Ciphertext: "a"}) {
+		t.Errorf("Unexpected local entries: %+v", ck.Keys["local"])
+	}
+	if len(ck.Keys["rotating.com"]) != 2 {
+		t.Errorf("Unexpected rotating.com entries: %+v", ck.Keys["rotating.com"])
+	}
+	if _, err := ParseCryptoKeys(`{"v":3,"keys":{}}`); err == nil {
+		t.Errorf("Expected failure on unknown version.")
+	}
+	ck, err = ParseCryptoKeys(`{"google.com":"a"}`)
+	if err != nil {
+		t.Fatalf("Failed to parse version 1 cryptokeys: %v", err)
+	}
+	if ck.Version != CryptoKeysV1 || ck.Keys["google.com"][0].Ciphertext != "a" {
+		t.Errorf("Unexpected version 1 cryptokeys: %+v", ck)
+	}
+	if _, err := ParseCryptoKeys(`{"google.com":[{"kid":"2","ct":"b"}]}`); err == nil {
+		t.Errorf("Expected failure on a list in version 1.")
+	}
+}
+
+func TestMergeCryptoKeys(t *testing.T) {
+	wrapped := map[string][]WrappedKey{
+		"local": []WrappedKey{{KeyID: "2", Ciphertext: "c"}},
+	}
+	if _, err := mergeCryptoKeys(`{"google.com":"a","local":"b"}`, wrapped, false, CryptoKeysV1, ContentParams{}); err == nil {
+		t.Errorf("Expected failure on an entry wrapping another document key.")
+	}
+	if merged, err := mergeCryptoKeys(`{"local":"b"}`, wrapped, false, CryptoKeysV1, ContentParams{}); err != nil || len(merged) != 1 || merged["local"][0].Ciphertext != "c" {
+		t.Errorf("Unexpected replaced entries: %+v, %v", merged, err)
+	}
+	merged, err := mergeCryptoKeys(`{"google.com":"a","local":"b"}`, wrapped, true, CryptoKeysV1, ContentParams{})
+	if err != nil {
+		t.Fatalf("Failed to merge cryptokeys: %v", err)
+	}
+	if len(merged) != 2 || merged["google.com"][0].Ciphertext != "a" {
+		t.Errorf("Unexpected google.com entries: %+v", merged["google.com"])
+	}
+	if len(merged["local"]) != 1 || merged["local"][0].Ciphertext != "c" {
+		t.Errorf("Unexpected local entries: %+v", merged["local"])
+	}
+	if _, err := mergeCryptoKeys(`not json`, nil, true, CryptoKeysV1, ContentParams{}); err == nil {
+		t.Errorf("Expected failure on malformed cryptokeys.")
+	}
+	v2 := `{"v":2,"alg":"A128GCM","zip":"DEF","keys":{"google.com":{"ct":"a"}}}`
+	if _, err := mergeCryptoKeys(v2, wrapped, true, CryptoKeysV2, ContentParams{Zip: ZipDeflate}); err != nil {
+		t.Errorf("Failed to merge matching content parameters: %v", err)
+	}
+	for _, c := range []struct {
+		version int
+		content ContentParams
+	}{
+		{CryptoKeysV1, ContentParams{}},
+		{CryptoKeysV2, ContentParams{}},
+		{CryptoKeysV2, ContentParams{Zip: ZipGzip}},
+		{CryptoKeysV2, ContentParams{Zip: ZipDeflate, Pad: PadPowerOfTwo}},
+		{CryptoKeysV2, ContentParams{Zip: ZipDeflate, SegmentSize: 4096}},
+	} {
+		if _, err := mergeCryptoKeys(v2, wrapped, true, c.version, c.content); err == nil {
+			t.Errorf("Expected failure merging into version %d with %+v.", c.version, c.content)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/aead"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	gcmpb "github.com/google/tink/go/proto/aes_gcm_go_proto"
+	"github.com/google/tink/go/tink"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Helper functions to unwrap SwG encrypted documents.
+
+// Public function to unwrap an encrypted document key from the cryptokeys
+// script. The key is only returned if the checker grants the credential one
+// of the access requirements stored alongside it.
+func DecryptDocumentKey(encryptedKey string, privKh *keyset.Handle, credential string, checker EntitlementChecker) ([]byte, error) {
+	u, err := NewTinkHybridUnwrapper(privKh)
+	if err != nil {
+		return nil, err
+	}
+	return UnwrapDocumentKey(encryptedKey, u, credential, checker)
+}
+
+// Public function to unwrap a cryptokeys entry written by any Recipient. The
+// key is only returned if the checker grants the credential one of the
+// access requirements stored alongside it.
+func UnwrapDocumentKey(wrapped string, u KeyUnwrapper, credential string, checker EntitlementChecker) ([]byte, error) {
+	swgKey, err := unwrapSwgEncryptionKey(wrapped, u)
+	if err != nil {
+		return nil, err
+	}
+	return checkSwgEncryptionKey(swgKey, credential, checker)
+}
+
+// Returns the document key if the checker grants the credential one of its
+// access requirements.
+func checkSwgEncryptionKey(swgKey *swgEncryptionKey, credential string, checker EntitlementChecker) ([]byte, error) {
+	entitled, err := checker.IsEntitled(credential, swgKey.AccessRequirements)
+	if err != nil {
+		return nil, err
+	}
+	if !entitled {
+		return nil, errors.New("Reader is not entitled to the document key.")
+	}
+	return base64.StdEncoding.DecodeString(swgKey.Key)
+}
+
+// Unwraps a cryptokeys entry into a swgEncryptionKey.
+func unwrapSwgEncryptionKey(wrapped string, u KeyUnwrapper) (*swgEncryptionKey, error) {
+	jsonData, err := u.UnwrapKey(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	var swgKey swgEncryptionKey
+	if err := json.Unmarshal(jsonData, &swgKey); err != nil {
+		return nil, err
+	}
+	return &swgKey, nil
+}
+
+// Public function to decrypt the encrypted sections of a document generated
+// by GenerateEncryptedDocument. The document key is unwrapped from the
+// domain's cryptokeys entry, which may be of any cryptokeys version, and the
+// cryptokeys script is removed from the output.
+func DecryptDocument(htmlStr string, domain string, u KeyUnwrapper, credential string, checker EntitlementChecker) (string, error) {
+	return DecryptDocumentWithOptions(htmlStr, domain, u, credential, checker, DecryptOptions{})
+}
+
+// Options of DecryptDocumentWithOptions.
+type DecryptOptions struct {
+	// Fetches the ciphertext of externalized sections, which fail to
+	// decrypt if nil.
+	Ciphertexts CiphertextSource
+}
+
+// Public function to decrypt the encrypted sections of a document as
+// DecryptDocument does, given the decryption options.
+func DecryptDocumentWithOptions(htmlStr string, domain string, u KeyUnwrapper, credential string, checker EntitlementChecker, opts DecryptOptions) (string, error) {
+	parsedHTML, err := html.Parse(strings.NewReader(htmlStr))
+	if err != nil {
+		return "", err
+	}
+	cryptoKeys := findScript(parsedHTML, "cryptokeys")
+	if cryptoKeys == nil {
+		return "", errors.New("No cryptokeys script found.")
+	}
+	ck, err := ParseCryptoKeys(scriptText(cryptoKeys))
+	if err != nil {
+		return "", err
+	}
+	docKey, err := UnwrapDomainDocumentKey(scriptText(cryptoKeys), domain, u, credential, checker)
+	if err != nil {
+		return "", err
+	}
+	cipher, err := newDocumentAEAD(docKey)
+	if err != nil {
+		return "", err
+	}
+	encryptedSections := getAllEncryptedSections(parsedHTML)
+	if len(encryptedSections) == 0 {
+		return "", errors.New("No encrypted sections found.")
+	}
+	for _, section := range encryptedSections {
+		if err := decryptSection(section, cipher, docKey, ck.Content, opts.Ciphertexts); err != nil {
+			return "", err
+		}
+	}
+	cryptoKeys.Parent.RemoveChild(cryptoKeys)
+	return renderNode(parsedHTML), nil
+}
+
+// Creates the AES-GCM AEAD of the input document key.
+func newDocumentAEAD(docKey []byte) (tink.AEAD, error) {
+	keyBuf, err := proto.Marshal(&gcmpb.AesGcmKey{KeyValue: docKey})
+	if err != nil {
+		return nil, err
+	}
+	ks := createAesGcmKeyset(keyBuf)
+	kh, err := insecurecleartextkeyset.Read(&keyset.MemReaderWriter{Keyset: &ks})
+	if err != nil {
+		return nil, err
+	}
+	return aead.New(kh)
+}
+
+// Replaces the ciphertext script of an encrypted section with the decrypted
+// content, undoing the processing described by the content parameters.
+// Externalized ciphertext is fetched from the source.
+func decryptSection(section *html.Node, cipher tink.AEAD, docKey []byte, params ContentParams, source CiphertextSource) error {
+	script := findCiphertextScript(section)
+	if script == nil {
+		return errors.New("Encrypted section has no ciphertext.")
+	}
+	var enc io.Reader = base64.NewDecoder(base64.StdEncoding, scriptTextReader(script))
+	if hasAttr(script, ciphertextSrcAttr) {
+		ct, err := fetchCiphertext(script, source)
+		if err != nil {
+			return err
+		}
+		enc = bytes.NewReader(ct)
+	}
+	content, err := openSection(enc, cipher, docKey, params)
+	if err != nil {
+		return err
+	}
+	nodes, err := html.ParseFragment(content, section)
+	if err != nil {
+		return err
+	}
+	if err := restoreMediaBlobs(section, nodes, cipher, params, source); err != nil {
+		return err
+	}
+	removeTeasers(section)
+	for _, n := range nodes {
+		section.InsertBefore(n, script)
+	}
+	section.RemoveChild(script)
+	return nil
+}
+
+// Returns a reader of the decrypted content of a section's binary
+// ciphertext. Segmented content is decrypted, unpadded and inflated as it is
+// read, and reads fail on any content that is not authentic.
+func openSection(enc io.Reader, cipher tink.AEAD, docKey []byte, params ContentParams) (io.Reader, error) {
+	var content io.Reader
+	if params.SegmentSize > 0 {
+		var err error
+		if content, err = NewSegmentedDecrypter(enc, docKey, params.SegmentSize); err != nil {
+			return nil, err
+		}
+	} else {
+		b, err := ioutil.ReadAll(enc)
+		if err != nil {
+			return nil, err
+		}
+		pt, err := cipher.Decrypt(b, nil)
+		if err != nil {
+			return nil, err
+		}
+		content = bytes.NewReader(pt)
+	}
+	content, err := newUnpadReader(content, params.Pad)
+	if err != nil {
+		return nil, err
+	}
+	return newInflateReader(content, params.Zip)
+}
+
+// Returns the first script element below n with the input attribute.
+func findScript(n *html.Node, attr string) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Script {
+		for _, a := range n.Attr {
+			if a.Key == attr {
+				return n
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findScript(c, attr); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Returns a reader of the text of a script without whitespace, which streams
+// base64 text without copying it.
+func scriptTextReader(n *html.Node) io.Reader {
+	var texts []io.Reader
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			texts = append(texts, strings.NewReader(c.Data))
+		}
+	}
+	return &noSpaceReader{r: io.MultiReader(texts...)}
+}
+
+// Drops ASCII whitespace from the content read from r.
+type noSpaceReader struct {
+	r io.Reader
+}
+
+func (s *noSpaceReader) Read(p []byte) (int, error) {
+	for {
+		n, err := s.r.Read(p)
+		kept := 0
+		for _, c := range p[:n] {
+			if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != '\f' {
+				p[kept] = c
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// Returns the text content of a script element.
+func scriptText(n *html.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
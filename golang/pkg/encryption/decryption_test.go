@@ -0,0 +1,163 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/google/tink/go/hybrid"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Generates a new hybrid key pair and returns the private handle along with
+// the public keyset.
+func newTestKeyPair(t *testing.T) (*keyset.Handle, tinkpb.Keyset) {
+	privKh, err := keyset.NewHandle(hybrid.ECIESHKDFAES128GCMKeyTemplate())
+	if err != nil {
+		t.Fatalf("Failed to generate private key: %v", err)
+	}
+	pubKh, err := privKh.Public()
+	if err != nil {
+		t.Fatalf("Failed to get public key: %v", err)
+	}
+	exported := &keyset.MemReaderWriter{}
+	if err := insecurecleartextkeyset.Write(pubKh, exported); err != nil {
+		t.Fatalf("Failed to export public key: %v", err)
+	}
+	return privKh, *exported.Keyset
+}
+
+// Returns the recipients of a new key pair for the "local" domain along with
+// the unwrapper of its private key.
+func newTestRecipient(t *testing.T) ([]Recipient, KeyUnwrapper) {
+	return newTestDomainRecipient(t, "local")
+}
+
+// Returns the recipients of a new key pair for the given domain along with
+// the unwrapper of its private key.
+func newTestDomainRecipient(t *testing.T, domain string) ([]Recipient, KeyUnwrapper) {
+	privKh, pubKs := newTestKeyPair(t)
+	recipients, err := NewTinkHybridRecipients(map[string]tinkpb.Keyset{domain: pubKs})
+	if err != nil {
+		t.Fatalf("Failed to create recipients: %v", err)
+	}
+	u, err := NewTinkHybridUnwrapper(privKh)
+	if err != nil {
+		t.Fatalf("Failed to create unwrapper: %v", err)
+	}
+	return recipients, u
+}
+
+func TestScriptTextReader(t *testing.T) {
+	script := &html.Node{Type: html.ElementNode, Data: "script", DataAtom: atom.Script}
+	for _, text := range []string{"\n  AAE", "CAw\r\n", "\tQ=  "} {
+		script.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+	}
+	b, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, scriptTextReader(script)))
+	if err != nil || !bytes.Equal(b, []byte{0, 1, 2, 3, 4}) {
+		t.Errorf("Got %v, %v; want 0 to 4", b, err)
+	}
+}
+
+func TestDecryptDocumentKeySuccess(t *testing.T) {
+	privKh, pubKs := newTestKeyPair(t)
+	docKey := []byte("0123456789abcdef")
+	encKeys, err := encryptDocumentKey(docKey, []string{"norcal.com:premium"}, map[string]tinkpb.Keyset{"local": pubKs})
+	if err != nil {
+		t.Fatalf("Failed to encrypt document key: %v", err)
+	}
+	checker := &StaticEntitlementChecker{Grants: map[string][]string{"reader": []string{"norcal.com:premium"}}}
+	key, err := DecryptDocumentKey(encKeys["local"], privKh, "reader", checker)
+	if err != nil {
+		t.Fatalf("Failed to decrypt document key: %v", err)
+	}
+	if !bytes.Equal(key, docKey) {
+		t.Errorf("Decrypted key %x; want %x", key, docKey)
+	}
+}
+
+func TestDecryptDocumentKeyNotEntitled(t *testing.T) {
+	privKh, pubKs := newTestKeyPair(t)
+	encKeys, err := encryptDocumentKey([]byte("0123456789abcdef"), []string{"norcal.com:premium"}, map[string]tinkpb.Keyset{"local": pubKs})
+	if err != nil {
+		t.Fatalf("Failed to encrypt document key: %v", err)
+	}
+	checker := &StaticEntitlementChecker{Grants: map[string][]string{"reader": []string{"norcal.com:basic"}}}
+	if _, err := DecryptDocumentKey(encKeys["local"], privKh, "reader", checker); err == nil {
+		t.Errorf("Expected failure for reader without entitlement.")
+	}
+}
+
+func TestDecryptDocumentRoundTrip(t *testing.T) {
+	htmlStr, err := loadTestFileString("sample_encryption.html")
+	if err != nil {
+		t.Fatalf("HTML file load failed.")
+	}
+	dir, err := ioutil.TempDir("", "ciphertext")
+	if err != nil {
+		t.Fatalf("Failed to create ciphertext directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ctDir := &CiphertextDir{Dir: dir, URLPrefix: "/ct/"}
+	signer, _ := newTestSigner(t)
+	recipients, u := newTestRecipient(t)
+	for _, tc := range []struct {
+		name string
+		opts EncryptOptions
+		// Text expected in the encrypted document.
+		want string
+	}{
+		{"version 1", EncryptOptions{}, `<script type="application/json" cryptokeys="">{"local":"`},
+		{"version 2", EncryptOptions{CryptoKeysVersion: CryptoKeysV2}, `{"v":2,"alg":"A128GCM","keys":{"local":{"kid":`},
+		{"gzip", EncryptOptions{CryptoKeysVersion: CryptoKeysV2, Content: ContentParams{Zip: ZipGzip}}, `"zip":"gzip"`},
+		{"deflate", EncryptOptions{CryptoKeysVersion: CryptoKeysV2, Content: ContentParams{Zip: ZipDeflate}}, `"zip":"DEF"`},
+		{"power of two padding", EncryptOptions{CryptoKeysVersion: CryptoKeysV2, Content: ContentParams{Pad: PadPowerOfTwo}}, `"pad":"pow2"`},
+		{"quantum padding", EncryptOptions{CryptoKeysVersion: CryptoKeysV2, Content: ContentParams{Zip: ZipDeflate, Pad: PadQuantum, PadQuantum: 4096}}, `"pad":"quantum","padq":4096`},
+		{"segments", EncryptOptions{CryptoKeysVersion: CryptoKeysV2, Content: ContentParams{SegmentSize: 32}}, `"seg":32`},
+		{"compressed padded segments", EncryptOptions{CryptoKeysVersion: CryptoKeysV2, Content: ContentParams{Zip: ZipGzip, Pad: PadPowerOfTwo, SegmentSize: 64}}, `"seg":64`},
+		{"external", EncryptOptions{CryptoKeysVersion: CryptoKeysV2, Content: ContentParams{Zip: ZipDeflate, SegmentSize: 64}, External: ctDir}, `ciphertext-src="/ct/`},
+		{"teaser", EncryptOptions{Teaser: TeaserOptions{Words: 4}}, teaserAttr},
+		{"signed", EncryptOptions{CryptoKeysVersion: CryptoKeysV2, Signer: signer}, signatureAttr},
+	} {
+		encDoc, err := GenerateEncryptedDocumentWithOptions(htmlStr, []string{"norcal.com:premium"}, recipients, tc.opts)
+		if err != nil {
+			t.Fatalf("Failed to encrypt %s document: %v", tc.name, err)
+		}
+		if strings.Contains(encDoc, "seriously premium content") {
+			t.Fatalf("Encrypted %s document holds the plaintext.", tc.name)
+		}
+		if !strings.Contains(encDoc, tc.want) {
+			t.Errorf("Encrypted %s document misses %s: %s", tc.name, tc.want, encDoc)
+		}
+		decDoc, err := DecryptDocumentWithOptions(encDoc, "local", u, "reader", testChecker, DecryptOptions{Ciphertexts: ctDir})
+		if err != nil {
+			t.Fatalf("Failed to decrypt %s document: %v", tc.name, err)
+		}
+		if !strings.Contains(decDoc, "This is some seriously premium content!") {
+			t.Errorf("Decrypted %s document misses the content: %s", tc.name, decDoc)
+		}
+		if strings.Contains(decDoc, "cryptokeys") || strings.Contains(decDoc, "ciphertext") || strings.Contains(decDoc, teaserAttr) {
+			t.Errorf("Decrypted %s document still holds encryption scripts: %s", tc.name, decDoc)
+		}
+	}
+}
@@ -17,19 +17,18 @@ package encryption
 import (
 	"bytes"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"github.com/golang/protobuf/proto"
-	"github.com/google/tink/go/aead"
 	"github.com/google/tink/go/core/registry"
-	"github.com/google/tink/go/hybrid"
-	"github.com/google/tink/go/insecurecleartextkeyset"
 	"github.com/google/tink/go/keyset"
 	gcmpb "github.com/google/tink/go/proto/aes_gcm_go_proto"
 	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"github.com/google/tink/go/tink"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
+	"io"
 	"net/http"
+	"os"
 	"strings"
 	"unicode/utf8"
 )
@@ -41,20 +40,63 @@ const aesGCMKeySize uint32 = 16
 
 // Public function to generate an encrypted HTML document given the original.
 func GenerateEncryptedDocument(htmlStr string, accessRequirements []string, pubKeys map[string]tinkpb.Keyset) (string, error) {
-	km, err := registry.GetKeyManager(aesGCMKeyURL)
+	recipients, err := NewTinkHybridRecipients(pubKeys)
 	if err != nil {
 		return "", err
 	}
-	key, err := generateNewAesGcmKey(km)
-	if err != nil {
-		return "", err
-	}
-	keyBuf, err := proto.Marshal(key)
+	return GenerateEncryptedDocumentForRecipients(htmlStr, accessRequirements, recipients)
+}
+
+// Public function to generate an encrypted HTML document given the original,
+// with the document key wrapped for each of the input recipients.
+func GenerateEncryptedDocumentForRecipients(htmlStr string, accessRequirements []string, recipients []Recipient) (string, error) {
+	return GenerateEncryptedDocumentWithOptions(htmlStr, accessRequirements, recipients, EncryptOptions{})
+}
+
+// Options of GenerateEncryptedDocumentWithOptions. The zero value produces
+// the same output as GenerateEncryptedDocumentForRecipients.
+type EncryptOptions struct {
+	// Version of the cryptokeys script, CryptoKeysV1 if unset.
+	CryptoKeysVersion int
+	// Merge the wrapped keys into a cryptokeys script already in the
+	// document, replacing the entries of the same domains. Documents that
+	// already have a cryptokeys script are rejected unless set. The entries
+	// of other domains are only kept if Unwrapper recovers the document key
+	// they wrap, which then encrypts the sections.
+	MergeCryptoKeys bool
+	// Handling of sections that are already encrypted. Such documents are
+	// rejected unless set.
+	EncryptedSections EncryptedSectionMode
+	// Unwrapper and domain of the existing cryptokeys entry holding the
+	// document key, used by EncryptedSectionsReencrypt and MergeCryptoKeys.
+	Unwrapper    KeyUnwrapper
+	UnwrapDomain string
+	// Processing of the section plaintext. Anything but the zero value
+	// requires CryptoKeysV2.
+	Content ContentParams
+	// Handling of the images of encrypted sections.
+	Media MediaOptions
+	// Stores the ciphertext of each section outside of the document, which
+	// keeps a reference script instead. Sections are kept inline if nil.
+	// Re-encryption reads existing external ciphertext from it if it is
+	// also a CiphertextSource.
+	External CiphertextSink
+	// Teaser kept in each encrypted section.
+	Teaser TeaserOptions
+	// Signs the cryptokeys and ciphertext of the document if set.
+	Signer tink.Signer
+	// Scan of the document for the plaintext of the encrypted sections.
+	Leaks LeakOptions
+}
+
+// Public function to generate an encrypted HTML document given the original,
+// the recipients of the document key and the output options.
+func GenerateEncryptedDocumentWithOptions(htmlStr string, accessRequirements []string, recipients []Recipient, opts EncryptOptions) (string, error) {
+	km, err := registry.GetKeyManager(aesGCMKeyURL)
 	if err != nil {
 		return "", err
 	}
-	ks := createAesGcmKeyset(keyBuf)
-	kh, err := insecurecleartextkeyset.Read(&keyset.MemReaderWriter{Keyset: &ks})
+	key, err := generateNewAesGcmKey(km)
 	if err != nil {
 		return "", err
 	}
@@ -66,16 +108,49 @@ func GenerateEncryptedDocument(htmlStr string, accessRequirements []string, pubK
 	if len(encryptedSections) == 0 {
 		return "", errors.New("No encrypted sections found.")
 	}
-	if err = encryptAllSections(parsedHTML, encryptedSections, kh); err != nil {
+	encryptedSections, err = prepareSections(parsedHTML, encryptedSections, opts)
+	if err != nil {
+		return "", err
+	}
+	if len(encryptedSections) == 0 {
+		// All sections are already encrypted and skipped.
+		return htmlStr, nil
+	}
+	sharedKey := false
+	if existing := findScript(parsedHTML, "cryptokeys"); existing != nil && opts.MergeCryptoKeys && opts.Unwrapper != nil {
+		// The kept entries must wrap the document key of the sections.
+		if key.KeyValue, err = unwrapExistingDocumentKey(scriptText(existing), opts); err != nil {
+			return "", err
+		}
+		sharedKey = true
+	}
+	var plaintext *shingleSet
+	if opts.Leaks.enabled() {
+		plaintext = newShingleSet(opts.Leaks.ShingleSize)
+		for _, section := range encryptedSections {
+			plaintext.add(textContent(section))
+		}
+	}
+	if err = encryptAllSections(parsedHTML, encryptedSections, key.KeyValue, opts); err != nil {
 		return "", err
 	}
-	encryptedKeys, err := encryptDocumentKey(key.KeyValue, accessRequirements, pubKeys)
+	encryptedKeys, err := wrapDocumentKey(key.KeyValue, accessRequirements, recipients)
 	if err != nil {
 		return "", err
 	}
-	if err = addEncryptedDocumentKeyToHead(encryptedKeys, parsedHTML); err != nil {
+	if err = addEncryptedDocumentKeyToHead(encryptedKeys, opts, sharedKey, parsedHTML); err != nil {
 		return "", err
 	}
+	if plaintext != nil {
+		if err = scanForLeaks(parsedHTML, plaintext, opts.Leaks); err != nil {
+			return "", err
+		}
+	}
+	if opts.Signer != nil {
+		if err = signDocument(parsedHTML, opts.Signer); err != nil {
+			return "", err
+		}
+	}
 	return renderNode(parsedHTML), nil
 }
 
@@ -93,6 +168,23 @@ func RetrieveTinkPublicKey(publicKeyURL string) (tinkpb.Keyset, error) {
 	return *ks, nil
 }
 
+// Loads a Tink public key from the given URL or local file.
+func LoadTinkPublicKey(location string) (tinkpb.Keyset, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return RetrieveTinkPublicKey(location)
+	}
+	f, err := os.Open(location)
+	if err != nil {
+		return tinkpb.Keyset{}, err
+	}
+	defer f.Close()
+	ks, err := keyset.NewJSONReader(f).Read()
+	if err != nil {
+		return tinkpb.Keyset{}, err
+	}
+	return *ks, nil
+}
+
 // Generates a new AES-GCM key.
 func generateNewAesGcmKey(km registry.KeyManager) (*gcmpb.AesGcmKey, error) {
 	p, err := proto.Marshal(&gcmpb.AesGcmKeyFormat{KeySize: aesGCMKeySize})
@@ -185,47 +277,163 @@ func getEncryptedSectionsDFS(bodyNode *html.Node) []*html.Node {
 	return encryptedSections
 }
 
-// Encrypts the content inside of the input "encryptedSections" nodes.
-func encryptAllSections(parsedHTML *html.Node, encryptedSections []*html.Node, kh *keyset.Handle) error {
-	cipher, err := aead.New(kh)
+// Encrypts the content inside of the input "encryptedSections" nodes, after
+// processing it as described by the content parameters. Images are handled
+// as described by the media options. The content is rendered straight into
+// the encryption, so with segments only the ciphertext of a section is held
+// in memory, as the script text or the externalized ciphertext.
+func encryptAllSections(parsedHTML *html.Node, encryptedSections []*html.Node, docKey []byte, opts EncryptOptions) error {
+	cipher, err := newDocumentAEAD(docKey)
+	if err != nil {
+		return err
+	}
+	tb, err := newTeaserBuilder(opts.Teaser)
 	if err != nil {
 		return err
 	}
+	var blobID int
 	for _, node := range encryptedSections {
-		var content []string
-		for {
-			c := node.FirstChild
-			if c == nil {
-				break
-			}
-			content = append(content, renderNode(c))
-			node.RemoveChild(c)
-		}
-		b := []byte(strings.Join(content, ""))
-		if !utf8.Valid(b) {
-			return errors.New("Content contains invalid UTF-8.")
+		blobs, err := prepareMedia(node, opts.Media, &blobID)
+		if err != nil {
+			return err
 		}
-		encContent, err := cipher.Encrypt(b, nil)
+		teaser, err := tb.build(node)
 		if err != nil {
 			return err
 		}
-		textNode := &html.Node{Type: html.TextNode, Data: base64.StdEncoding.EncodeToString(encContent)}
-		attrs := []html.Attribute{
-			html.Attribute{Key: "type", Val: "application/octet-stream"},
-			html.Attribute{Key: "ciphertext", Val: ""},
+		var scriptNode *html.Node
+		if opts.External != nil {
+			var ct bytes.Buffer
+			if err := sealSection(&ct, node, cipher, docKey, opts.Content); err != nil {
+				return err
+			}
+			if scriptNode, err = externalizeCiphertext(ct.Bytes(), opts.External); err != nil {
+				return err
+			}
+		} else {
+			var encContent strings.Builder
+			b64 := base64.NewEncoder(base64.StdEncoding, &encContent)
+			if err := sealSection(b64, node, cipher, docKey, opts.Content); err != nil {
+				return err
+			}
+			b64.Close()
+			textNode := &html.Node{Type: html.TextNode, Data: encContent.String()}
+			attrs := []html.Attribute{
+				html.Attribute{Key: "type", Val: "application/octet-stream"},
+				html.Attribute{Key: "ciphertext", Val: ""},
+			}
+			scriptNode = &html.Node{
+				Type:     html.ElementNode,
+				Data:     "script",
+				DataAtom: atom.Script,
+				Attr:     attrs,
+			}
+			scriptNode.AppendChild(textNode)
 		}
-		scriptNode := &html.Node{
-			Type:     html.ElementNode,
-			Data:     "script",
-			DataAtom: atom.Script,
-			Attr:     attrs,
+		if teaser != nil {
+			node.AppendChild(teaser)
 		}
 		node.AppendChild(scriptNode)
-		scriptNode.AppendChild(textNode)
+		if err := appendMediaBlobs(node, blobs, cipher, opts.Content, opts.External); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// Moves the content of the section into its encryption, processing it as
+// described by the content parameters, and writes the binary ciphertext to w.
+// The content is rendered, compressed, padded and then encrypted as one
+// AES-GCM message, which buffers it, or in the segmented format if a segment
+// size is set, which streams it.
+func sealSection(w io.Writer, section *html.Node, cipher tink.AEAD, docKey []byte, params ContentParams) error {
+	var enc io.WriteCloser = &aeadWriter{w: w, cipher: cipher}
+	if params.SegmentSize > 0 {
+		var err error
+		if enc, err = NewSegmentedEncrypter(w, docKey, params.SegmentSize); err != nil {
+			return err
+		}
+	}
+	padded, err := newPadWriter(enc, params.Pad, params.PadQuantum)
+	if err != nil {
+		return err
+	}
+	zw, err := newCompressWriter(padded, params.Zip)
+	if err != nil {
+		return err
+	}
+	content := &utf8Writer{w: zw}
+	for c := section.FirstChild; c != nil; c = section.FirstChild {
+		if err := html.Render(content, c); err != nil {
+			return err
+		}
+		section.RemoveChild(c)
+	}
+	for _, c := range []io.Closer{content, zw, padded, enc} {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Passes the content written to it on to w, failing on invalid UTF-8.
+type utf8Writer struct {
+	w io.Writer
+	// Start of a rune that continues in the next write.
+	tail []byte
+}
+
+func (u *utf8Writer) Write(p []byte) (int, error) {
+	b := p
+	if len(u.tail) != 0 {
+		b = append(u.tail, p...)
+	}
+	// Holds back a trailing incomplete rune.
+	end := len(b)
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+	if !utf8.Valid(b[:end]) {
+		return 0, errors.New("Content contains invalid UTF-8.")
+	}
+	u.tail = append([]byte(nil), b[end:]...)
+	return u.w.Write(p)
+}
+
+func (u *utf8Writer) Close() error {
+	if len(u.tail) != 0 {
+		return errors.New("Content contains invalid UTF-8.")
+	}
+	return nil
+}
+
+// Buffers the content written to it and encrypts it as one AEAD message on
+// Close.
+type aeadWriter struct {
+	w      io.Writer
+	cipher tink.AEAD
+	buf    bytes.Buffer
+}
+
+func (a *aeadWriter) Write(p []byte) (int, error) {
+	return a.buf.Write(p)
+}
+
+func (a *aeadWriter) Close() error {
+	ct, err := a.cipher.Encrypt(a.buf.Bytes(), nil)
+	if err != nil {
+		return err
+	}
+	_, err = a.w.Write(ct)
+	return err
+}
+
 type swgEncryptionKey struct {
 	AccessRequirements []string
 	Key                string
@@ -233,35 +441,42 @@ type swgEncryptionKey struct {
 
 // Encrypts the document's symmetric key using the input Keyset.
 func encryptDocumentKey(docKey []byte, accessRequirements []string, pubKeys map[string]tinkpb.Keyset) (map[string]string, error) {
+	recipients, err := NewTinkHybridRecipients(pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	wrappedKeys, err := wrapDocumentKey(docKey, accessRequirements, recipients)
+	if err != nil {
+		return nil, err
+	}
 	outMap := make(map[string]string)
-	for domain, ks := range pubKeys {
-		handle, err := keyset.NewHandleWithNoSecrets(&ks)
-		if err != nil {
-			return nil, err
+	for domain, keys := range wrappedKeys {
+		outMap[domain] = keys[0].Ciphertext
+	}
+	return outMap, nil
+}
+
+// Adds the encrypted document keys to the output document's head. An
+// existing cryptokeys script is merged into if the options allow it.
+func addEncryptedDocumentKeyToHead(encryptedKeys map[string][]WrappedKey, opts EncryptOptions, sharedKey bool, parsedHTML *html.Node) error {
+	if existing := findScript(parsedHTML, "cryptokeys"); existing != nil {
+		if !opts.MergeCryptoKeys {
+			return errors.New("The document already has a cryptokeys script. Remove it, or enable merging to replace its entries.")
 		}
-		he, err := hybrid.NewHybridEncrypt(handle)
+		merged, err := mergeCryptoKeys(scriptText(existing), encryptedKeys, sharedKey, opts.CryptoKeysVersion, opts.Content)
 		if err != nil {
-			return nil, err
-		}
-		swgKey := swgEncryptionKey{
-			AccessRequirements: accessRequirements,
-			Key:                base64.StdEncoding.EncodeToString(docKey),
+			return err
 		}
-		jsonData, err := json.Marshal(swgKey)
+		jsonEncKeys, err := marshalCryptoKeys(merged, opts.CryptoKeysVersion, opts.Content)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		enc, err := he.Encrypt(jsonData, nil)
-		if err != nil {
-			return nil, err
+		for existing.FirstChild != nil {
+			existing.RemoveChild(existing.FirstChild)
 		}
-		outMap[domain] = base64.StdEncoding.EncodeToString(enc)
+		existing.AppendChild(&html.Node{Type: html.TextNode, Data: string(jsonEncKeys)})
+		return nil
 	}
-	return outMap, nil
-}
-
-// Adds the encrypted document keys to the output document's head.
-func addEncryptedDocumentKeyToHead(encryptedKeys map[string]string, parsedHTML *html.Node) error {
 	for n := parsedHTML.FirstChild; n != nil; n = n.NextSibling {
 		if (n.DataAtom == atom.Html) && (len(n.Attr) != 0) {
 			for cn := n.FirstChild; cn != nil; cn = cn.NextSibling {
@@ -276,7 +491,7 @@ func addEncryptedDocumentKeyToHead(encryptedKeys map[string]string, parsedHTML *
 						DataAtom: atom.Script,
 						Attr:     attrs,
 					}
-					jsonEncKeys, err := json.Marshal(encryptedKeys)
+					jsonEncKeys, err := marshalCryptoKeys(encryptedKeys, opts.CryptoKeysVersion, opts.Content)
 					if err != nil {
 						return err
 					}
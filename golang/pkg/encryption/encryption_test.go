@@ -15,6 +15,7 @@
 package encryption
 
 import (
+	"bytes"
 	"github.com/google/tink/go/keyset"
 	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
 	"io/ioutil"
@@ -135,6 +136,20 @@ func TestEncryptDocumentEmptyKeyset(t *testing.T) {
 	}
 }
 
+func TestEncryptDocumentNoPublicKeys(t *testing.T) {
+	htmlStr, err := loadTestFileString("sample_encryption.html")
+	if err != nil {
+		t.Fatalf("HTML file load failed.")
+	}
+	encDoc, err := GenerateEncryptedDocument(htmlStr, []string{"norcal.com:premium"}, map[string]tinkpb.Keyset{})
+	if err != nil {
+		t.Fatalf("Error occured generating encrypted document: %s", err.Error())
+	}
+	if !strings.Contains(encDoc, `<script type="application/json" cryptokeys="">{}</script>`) {
+		t.Errorf("Missing empty cryptokeys script.")
+	}
+}
+
 func TestEncryptDocumentNoEncryptSections(t *testing.T) {
 	htmlStr := `<!doctype html><html ⚡>
 	<head>
@@ -195,3 +210,69 @@ func TestEncryptDocumentHebrew(t *testing.T) {
 		t.Errorf("Missing google.com key.")
 	}
 }
+
+func TestUTF8Writer(t *testing.T) {
+	var out bytes.Buffer
+	w := &utf8Writer{w: &out}
+	in := []byte("שלום, €!")
+	for i := range in {
+		if _, err := w.Write(in[i : i+1]); err != nil {
+			t.Fatalf("Failed to write byte %d: %v", i, err)
+		}
+	}
+	if err := w.Close(); err != nil || !bytes.Equal(out.Bytes(), in) {
+		t.Errorf("Got %q, %v; want %q", out.Bytes(), err, in)
+	}
+	w = &utf8Writer{w: &out}
+	if _, err := w.Write([]byte("a\xffb")); err == nil {
+		t.Errorf("Expected failure on invalid UTF-8.")
+	}
+	w = &utf8Writer{w: &out}
+	if _, err := w.Write([]byte("a\xe2\x82")); err != nil {
+		t.Errorf("Failed on incomplete rune: %v", err)
+	}
+	if err := w.Close(); err == nil {
+		t.Errorf("Expected failure on truncated rune.")
+	}
+}
+
+func TestEncryptDocumentExistingCryptoKeys(t *testing.T) {
+	htmlStr, err := loadTestFileString("sample_encryption.html")
+	if err != nil {
+		t.Fatalf("HTML file load failed.")
+	}
+	ar := []string{"norcal.com:premium"}
+	// The page template's script wraps its own document key for both domains.
+	recipients, u := newTestRecipient(t)
+	otherRecipients, otherU := newTestDomainRecipient(t, "other.com")
+	existing, err := wrapDocumentKey([]byte("0123456789abcdef"), ar, append(otherRecipients, recipients...))
+	if err != nil {
+		t.Fatalf("Failed to wrap existing document key: %v", err)
+	}
+	b, err := marshalCryptoKeys(existing, CryptoKeysV1, ContentParams{})
+	if err != nil {
+		t.Fatalf("Failed to marshal existing cryptokeys: %v", err)
+	}
+	htmlStr = strings.Replace(htmlStr, "<head>", `<head><script type="application/json" cryptokeys>`+string(b)+`</script>`, 1)
+	if _, err := GenerateEncryptedDocumentForRecipients(htmlStr, ar, recipients); err == nil {
+		t.Fatalf("Expected failure on existing cryptokeys script.")
+	}
+	if _, err := GenerateEncryptedDocumentWithOptions(htmlStr, ar, recipients, EncryptOptions{MergeCryptoKeys: true}); err == nil {
+		t.Fatalf("Expected failure on keeping an entry of another document key.")
+	}
+	encDoc, err := GenerateEncryptedDocumentWithOptions(htmlStr, ar, recipients, EncryptOptions{MergeCryptoKeys: true, Unwrapper: u, UnwrapDomain: "local"})
+	if err != nil {
+		t.Fatalf("Failed to merge into existing cryptokeys script: %v", err)
+	}
+	if n := strings.Count(encDoc, "cryptokeys"); n != 1 {
+		t.Errorf("Got %d cryptokeys scripts; want 1", n)
+	}
+	for domain, du := range map[string]KeyUnwrapper{"local": u, "other.com": otherU} {
+		decDoc, err := DecryptDocument(encDoc, domain, du, "reader", testChecker)
+		if err != nil {
+			t.Errorf("Failed to decrypt merged document for %s: %v", domain, err)
+		} else if !strings.Contains(decDoc, "This is some seriously premium content!") {
+			t.Errorf("Decrypted document for %s misses the content.", domain)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Helper types to decide which readers may unwrap a document key.
+
+const jwtAlgHS256 string = "HS256"
+const jwtAlgES256 string = "ES256"
+
+// Decides whether a reader is granted the "publication:product" access
+// requirements embedded in an encrypted document key.
+type EntitlementChecker interface {
+	// Returns true if the reader identified by the input credential is granted
+	// at least one of the input access requirements.
+	IsEntitled(credential string, accessRequirements []string) (bool, error)
+}
+
+// Grants products from a fixed map of credential to products.
+type StaticEntitlementChecker struct {
+	Grants map[string][]string
+}
+
+// Returns true if the credential is granted one of the access requirements.
+func (c *StaticEntitlementChecker) IsEntitled(credential string, accessRequirements []string) (bool, error) {
+	products, ok := c.Grants[credential]
+	if !ok {
+		return false, nil
+	}
+	return grantsAny(products, accessRequirements), nil
+}
+
+// Grants the products listed in the "products" claim of a signed JWT.
+type JWTEntitlementChecker struct {
+	alg      string
+	hmacKey  []byte
+	ecdsaKey *ecdsa.PublicKey
+	now      func() time.Time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+type jwtClaims struct {
+	Exp      int64    `json:"exp,omitempty"`
+	Nbf      int64    `json:"nbf,omitempty"`
+	Products []string `json:"products"`
+}
+
+// Public function to create a JWTEntitlementChecker given the signing
+// algorithm ("HS256" or "ES256") and the path to the verification key. HS256
+// keys are read as the raw shared secret, ES256 keys as a PEM encoded P-256
+// public key.
+func NewJWTEntitlementChecker(alg string, keyFile string) (*JWTEntitlementChecker, error) {
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	c := &JWTEntitlementChecker{alg: alg, now: time.Now}
+	switch alg {
+	case jwtAlgHS256:
+		c.hmacKey = bytes.TrimSpace(b)
+		if len(c.hmacKey) == 0 {
+			return nil, errors.New("HS256 key file is empty.")
+		}
+	case jwtAlgES256:
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, errors.New("ES256 key file does not contain a PEM block.")
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		ecKey, ok := pub.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve.Params().Name != "P-256" {
+			return nil, errors.New("ES256 key is not a P-256 public key.")
+		}
+		c.ecdsaKey = ecKey
+	default:
+		return nil, errors.New("Unsupported JWT algorithm: " + alg)
+	}
+	return c, nil
+}
+
+// Verifies the input JWT and returns true if it grants one of the access
+// requirements.
+func (c *JWTEntitlementChecker) IsEntitled(credential string, accessRequirements []string) (bool, error) {
+	claims, err := c.verify(credential)
+	if err != nil {
+		return false, err
+	}
+	return grantsAny(claims.Products, accessRequirements), nil
+}
+
+// Verifies the signature and validity period of the input JWT and returns
+// its claims.
+func (c *JWTEntitlementChecker) verify(token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed JWT.")
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(hb, &header); err != nil {
+		return nil, err
+	}
+	// The algorithm is fixed by the configured key, never by the token.
+	if header.Alg != c.alg {
+		return nil, errors.New("Unexpected JWT algorithm: " + header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch c.alg {
+	case jwtAlgHS256:
+		mac := hmac.New(sha256.New, c.hmacKey)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return nil, errors.New("Invalid JWT signature.")
+		}
+	case jwtAlgES256:
+		if len(sig) != 64 {
+			return nil, errors.New("Invalid JWT signature.")
+		}
+		digest := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(c.ecdsaKey, digest[:], r, s) {
+			return nil, errors.New("Invalid JWT signature.")
+		}
+	}
+	cb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(cb, &claims); err != nil {
+		return nil, err
+	}
+	now := c.now().Unix()
+	if claims.Exp != 0 && now >= claims.Exp {
+		return nil, errors.New("JWT has expired.")
+	}
+	if claims.Nbf != 0 && now < claims.Nbf {
+		return nil, errors.New("JWT is not yet valid.")
+	}
+	return &claims, nil
+}
+
+// Returns true if any of the granted products is an access requirement.
+func grantsAny(products []string, accessRequirements []string) bool {
+	for _, p := range products {
+		for _, ar := range accessRequirements {
+			if p == ar {
+				return true
+			}
+		}
+	}
+	return false
+}
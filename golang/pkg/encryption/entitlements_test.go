@@ -0,0 +1,159 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testHS256Secret string = "not-a-real-secret"
+
+func writeTempKeyFile(t *testing.T, data []byte) (string, func()) {
+	dir, err := ioutil.TempDir("", "entitlements")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	path := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func signTestJWT(t *testing.T, header string, claims string, sign func([]byte) []byte) string {
+	signed := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func signHS256(b []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(testHS256Secret))
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+func TestStaticEntitlementChecker(t *testing.T) {
+	c := &StaticEntitlementChecker{Grants: map[string][]string{
+		"reader1": []string{"norcal.com:premium"},
+	}}
+	ok, err := c.IsEntitled("reader1", []string{"norcal.com:basic", "norcal.com:premium"})
+	if err != nil || !ok {
+		t.Errorf("reader1 should be entitled; got %v, %v", ok, err)
+	}
+	ok, err = c.IsEntitled("reader2", []string{"norcal.com:premium"})
+	if err != nil || ok {
+		t.Errorf("reader2 should not be entitled; got %v, %v", ok, err)
+	}
+}
+
+func TestJWTEntitlementCheckerHS256(t *testing.T) {
+	path, cleanup := writeTempKeyFile(t, []byte(testHS256Secret+"\n"))
+	defer cleanup()
+	c, err := NewJWTEntitlementChecker("HS256", path)
+	if err != nil {
+		t.Fatalf("Failed to create checker: %v", err)
+	}
+	token := signTestJWT(t, `{"alg":"HS256","typ":"JWT"}`, `{"products":["norcal.com:premium"]}`, signHS256)
+	ok, err := c.IsEntitled(token, []string{"norcal.com:premium"})
+	if err != nil || !ok {
+		t.Errorf("Token should grant norcal.com:premium; got %v, %v", ok, err)
+	}
+	ok, err = c.IsEntitled(token, []string{"thenews.com:premium"})
+	if err != nil || ok {
+		t.Errorf("Token should not grant thenews.com:premium; got %v, %v", ok, err)
+	}
+	if _, err := c.IsEntitled(token[:len(token)-2], []string{"norcal.com:premium"}); err == nil {
+		t.Errorf("Expected failure on tampered signature.")
+	}
+}
+
+func TestJWTEntitlementCheckerExpired(t *testing.T) {
+	path, cleanup := writeTempKeyFile(t, []byte(testHS256Secret))
+	defer cleanup()
+	c, err := NewJWTEntitlementChecker("HS256", path)
+	if err != nil {
+		t.Fatalf("Failed to create checker: %v", err)
+	}
+	c.now = func() time.Time { return time.Unix(2000, 0) }
+	token := signTestJWT(t, `{"alg":"HS256"}`, `{"exp":1000,"products":["norcal.com:premium"]}`, signHS256)
+	if _, err := c.IsEntitled(token, []string{"norcal.com:premium"}); err == nil {
+		t.Errorf("Expected failure on expired token.")
+	}
+}
+
+func TestJWTEntitlementCheckerAlgMismatch(t *testing.T) {
+	path, cleanup := writeTempKeyFile(t, []byte(testHS256Secret))
+	defer cleanup()
+	c, err := NewJWTEntitlementChecker("HS256", path)
+	if err != nil {
+		t.Fatalf("Failed to create checker: %v", err)
+	}
+	token := signTestJWT(t, `{"alg":"none"}`, `{"products":["norcal.com:premium"]}`, signHS256)
+	if _, err := c.IsEntitled(token, []string{"norcal.com:premium"}); err == nil {
+		t.Errorf("Expected failure on unexpected algorithm.")
+	}
+}
+
+func TestJWTEntitlementCheckerES256(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	path, cleanup := writeTempKeyFile(t, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	defer cleanup()
+	c, err := NewJWTEntitlementChecker("ES256", path)
+	if err != nil {
+		t.Fatalf("Failed to create checker: %v", err)
+	}
+	signES256 := func(b []byte) []byte {
+		digest := sha256.Sum256(b)
+		r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig
+	}
+	token := signTestJWT(t, `{"alg":"ES256"}`, `{"products":["norcal.com:premium"]}`, signES256)
+	ok, err := c.IsEntitled(token, []string{"norcal.com:premium"})
+	if err != nil || !ok {
+		t.Errorf("Token should grant norcal.com:premium; got %v, %v", ok, err)
+	}
+}
+
+func TestNewJWTEntitlementCheckerUnsupportedAlg(t *testing.T) {
+	path, cleanup := writeTempKeyFile(t, []byte(testHS256Secret))
+	defer cleanup()
+	if _, err := NewJWTEntitlementChecker("RS256", path); err == nil {
+		t.Errorf("Expected failure on unsupported algorithm.")
+	}
+}
@@ -0,0 +1,241 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Helper functions to store section ciphertext outside of the document, so
+// that a CDN can cache it separately. The section then holds a reference
+// script instead of the ciphertext:
+//
+// 	<script type="application/octet-stream" ciphertext-src="<url>" integrity="sha384-<hash>"></script>
+//
+// The integrity value is a Subresource Integrity hash of the binary
+// ciphertext at the URL.
+
+// Attribute of the reference script of an externalized section.
+const ciphertextSrcAttr string = "ciphertext-src"
+
+// Largest externalized ciphertext fetched by default, in bytes.
+const defaultMaxExternalCiphertextSize int64 = 64 << 20
+
+// Stores the binary ciphertext of externalized sections.
+type CiphertextSink interface {
+	// Stores the ciphertext under the input file name and returns the URL
+	// the section references it by.
+	PutCiphertext(name string, ciphertext []byte) (string, error)
+}
+
+// Fetches the binary ciphertext of externalized sections.
+type CiphertextSource interface {
+	// Returns the ciphertext at the input URL.
+	GetCiphertext(url string) ([]byte, error)
+}
+
+// Stores ciphertext as files in a directory served under a URL prefix, and
+// reads them back for URLs with that prefix.
+type CiphertextDir struct {
+	Dir       string
+	URLPrefix string
+}
+
+// Writes the ciphertext to a file of the directory. The file is written to a
+// temporary file first and renamed into place, so that a crash or a
+// concurrent reader never sees a partial file that fails its integrity
+// check.
+func (d *CiphertextDir) PutCiphertext(name string, ciphertext []byte) (string, error) {
+	if err := writeFileAtomic(filepath.Join(d.Dir, name), ciphertext, 0644); err != nil {
+		return "", err
+	}
+	return d.URLPrefix + name, nil
+}
+
+// Writes data to a synced temporary file in the directory of path and renames
+// it over path.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// Reads the file of the directory that the URL refers to.
+func (d *CiphertextDir) GetCiphertext(url string) ([]byte, error) {
+	if !strings.HasPrefix(url, d.URLPrefix) {
+		return nil, errors.New("Ciphertext URL " + url + " is not in the ciphertext directory.")
+	}
+	name := strings.TrimPrefix(url, d.URLPrefix)
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return nil, errors.New("Ciphertext URL " + url + " is not in the ciphertext directory.")
+	}
+	return ioutil.ReadFile(filepath.Join(d.Dir, name))
+}
+
+// Fetches ciphertext over HTTP.
+type HTTPCiphertextSource struct {
+	// Client used for requests, http.DefaultClient if nil.
+	Client *http.Client
+	// Largest ciphertext fetched, in bytes. Defaults to 64 MiB.
+	MaxSize int64
+}
+
+// Fetches the ciphertext at the URL.
+func (s *HTTPCiphertextSource) GetCiphertext(url string) ([]byte, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	maxSize := s.MaxSize
+	if maxSize == 0 {
+		maxSize = defaultMaxExternalCiphertextSize
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching ciphertext %s failed with status %d.", url, resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > maxSize {
+		return nil, errors.New("Ciphertext " + url + " exceeds the size limit.")
+	}
+	return b, nil
+}
+
+// Stores the ciphertext in the sink under a name derived from its hash, and
+// returns the reference script of the section.
+func externalizeCiphertext(ciphertext []byte, sink CiphertextSink) (*html.Node, error) {
+	sum := sha256.Sum256(ciphertext)
+	url, err := sink.PutCiphertext(hex.EncodeToString(sum[:16])+".bin", ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return &html.Node{
+		Type:     html.ElementNode,
+		Data:     "script",
+		DataAtom: atom.Script,
+		Attr: []html.Attribute{
+			html.Attribute{Key: "type", Val: "application/octet-stream"},
+			html.Attribute{Key: ciphertextSrcAttr, Val: url},
+			html.Attribute{Key: "integrity", Val: integrityHash(ciphertext)},
+		},
+	}, nil
+}
+
+// Fetches the ciphertext of a reference script and checks its integrity.
+func fetchCiphertext(script *html.Node, source CiphertextSource) ([]byte, error) {
+	if source == nil {
+		return nil, errors.New("Externalized ciphertext requires a ciphertext source.")
+	}
+	ciphertext, err := source.GetCiphertext(getAttr(script, ciphertextSrcAttr))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIntegrity(ciphertext, getAttr(script, "integrity")); err != nil {
+		return nil, err
+	}
+	return ciphertext, nil
+}
+
+// Returns the Subresource Integrity value of the input.
+func integrityHash(b []byte) string {
+	sum := sha512.Sum384(b)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Checks the input against a Subresource Integrity value. As in browsers,
+// any of several space separated hashes may match.
+func checkIntegrity(b []byte, integrity string) error {
+	for _, value := range strings.Fields(integrity) {
+		i := strings.Index(value, "-")
+		if i < 0 {
+			continue
+		}
+		var h hash.Hash
+		switch value[:i] {
+		case "sha256":
+			h = sha256.New()
+		case "sha384":
+			h = sha512.New384()
+		case "sha512":
+			h = sha512.New()
+		default:
+			continue
+		}
+		want, err := base64.StdEncoding.DecodeString(value[i+1:])
+		if err != nil {
+			continue
+		}
+		h.Write(b)
+		if hmac.Equal(h.Sum(nil), want) {
+			return nil
+		}
+	}
+	return errors.New("Ciphertext does not match its integrity hash.")
+}
+
+// Returns the ciphertext or reference script at or below n, leaving out the
+// scripts of image blobs.
+func findCiphertextScript(n *html.Node) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Script && !hasAttr(n, blobScriptAttr) && (hasAttr(n, "ciphertext") || hasAttr(n, ciphertextSrcAttr)) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findCiphertextScript(c); found != nil {
+			return found
+		}
+	}
+	return nil
+}
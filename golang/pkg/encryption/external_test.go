@@ -0,0 +1,148 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	b := []byte("ciphertext")
+	sum := sha256.Sum256(b)
+	sha256Value := "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+	for _, integrity := range []string{integrityHash(b), sha256Value, "sha1-abc " + sha256Value, "sha256-AAAA " + integrityHash(b)} {
+		if err := checkIntegrity(b, integrity); err != nil {
+			t.Errorf("Integrity %q failed: %v", integrity, err)
+		}
+	}
+	for _, integrity := range []string{"", integrityHash([]byte("other")), "sha1-abc", "md5-" + base64.StdEncoding.EncodeToString(sum[:])} {
+		if err := checkIntegrity(b, integrity); err == nil {
+			t.Errorf("Expected failure on integrity %q.", integrity)
+		}
+	}
+}
+
+func TestCiphertextDirOutsideURLs(t *testing.T) {
+	d := &CiphertextDir{Dir: "testdata", URLPrefix: "https://cdn.example.com/ct/"}
+	for _, url := range []string{
+		"https://other.example.com/ct/a.bin",
+		"https://cdn.example.com/ct/../google_public_key.json",
+		"https://cdn.example.com/ct/",
+		"https://cdn.example.com/ct/..",
+	} {
+		if _, err := d.GetCiphertext(url); err == nil {
+			t.Errorf("Expected failure on URL %s.", url)
+		}
+	}
+}
+
+func TestCiphertextDirPutCiphertext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ciphertext")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	d := &CiphertextDir{Dir: dir, URLPrefix: "https://cdn.example.com/ct/"}
+	for _, ct := range []string{"old ciphertext", "ciphertext"} {
+		url, err := d.PutCiphertext("a.bin", []byte(ct))
+		if err != nil || url != "https://cdn.example.com/ct/a.bin" {
+			t.Fatalf("Failed to put ciphertext: %s, %v", url, err)
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to list directory: %v", err)
+	}
+	if len(files) != 1 || files[0].Name() != "a.bin" || files[0].Mode().Perm() != 0644 {
+		t.Errorf("Unexpected directory contents: %v", files)
+	}
+	if b, err := d.GetCiphertext("https://cdn.example.com/ct/a.bin"); err != nil || string(b) != "ciphertext" {
+		t.Errorf("Read back %q, %v", b, err)
+	}
+}
+
+func TestEncryptDocumentExternal(t *testing.T) {
+	htmlStr, err := loadTestFileString("sample_encryption.html")
+	if err != nil {
+		t.Fatalf("HTML file load failed.")
+	}
+	dir, err := ioutil.TempDir("", "ciphertext")
+	if err != nil {
+		t.Fatalf("Failed to create ciphertext directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	recipients, u := newTestRecipient(t)
+	ctDir := &CiphertextDir{Dir: dir, URLPrefix: "/ct/"}
+	opts := EncryptOptions{
+		CryptoKeysVersion: CryptoKeysV2,
+		Content:           ContentParams{Zip: ZipDeflate, SegmentSize: 64},
+		External:          ctDir,
+	}
+	encDoc, err := GenerateEncryptedDocumentWithOptions(htmlStr, []string{"norcal.com:premium"}, recipients, opts)
+	if err != nil {
+		t.Fatalf("Failed to encrypt document: %v", err)
+	}
+	if !strings.Contains(encDoc, `ciphertext-src="/ct/`) || !strings.Contains(encDoc, `integrity="sha384-`) || strings.Contains(encDoc, "ciphertext=") {
+		t.Errorf("Missing reference script: %s", encDoc)
+	}
+	if !strings.Contains(encDoc, "cryptokeys") {
+		t.Errorf("Missing inline cryptokeys script.")
+	}
+	if _, err := DecryptDocument(encDoc, "local", u, "reader", testChecker); err == nil {
+		t.Errorf("Expected failure without ciphertext source.")
+	}
+	server := httptest.NewServer(http.StripPrefix("/ct/", http.FileServer(http.Dir(dir))))
+	defer server.Close()
+	httpDoc := strings.Replace(encDoc, `ciphertext-src="/ct/`, `ciphertext-src="`+server.URL+`/ct/`, 1)
+	decDoc, err := DecryptDocumentWithOptions(httpDoc, "local", u, "reader", testChecker, DecryptOptions{Ciphertexts: &HTTPCiphertextSource{}})
+	if err != nil {
+		t.Fatalf("Failed to decrypt document from HTTP: %v", err)
+	}
+	if !strings.Contains(decDoc, "This is some seriously premium content!") {
+		t.Errorf("Decrypted document misses the content: %s", decDoc)
+	}
+	if _, err := DecryptDocumentWithOptions(httpDoc, "local", u, "reader", testChecker, DecryptOptions{Ciphertexts: &HTTPCiphertextSource{MaxSize: 8}}); err == nil {
+		t.Errorf("Expected failure on oversized ciphertext.")
+	}
+	reencDoc, err := GenerateEncryptedDocumentWithOptions(encDoc, []string{"norcal.com:premium"}, recipients, EncryptOptions{
+		EncryptedSections: EncryptedSectionsReencrypt,
+		Unwrapper:         u,
+		UnwrapDomain:      "local",
+		External:          ctDir,
+	})
+	if err != nil {
+		t.Fatalf("Failed to re-encrypt document: %v", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.bin"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("Got ciphertext files %v; want 2", files)
+	}
+	for _, f := range files {
+		if err := ioutil.WriteFile(f, []byte("tampered"), 0644); err != nil {
+			t.Fatalf("Failed to tamper with ciphertext: %v", err)
+		}
+	}
+	if _, err := DecryptDocumentWithOptions(reencDoc, "local", u, "reader", testChecker, DecryptOptions{Ciphertexts: ctDir}); err == nil {
+		t.Errorf("Expected failure on tampered ciphertext.")
+	}
+}
@@ -0,0 +1,345 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Helper functions to wrap document keys as compact JWE (RFC 7516) for
+// partners using JOSE libraries.
+
+const jweAlgECDHESA128KW string = "ECDH-ES+A128KW"
+const jweEncA128GCM string = "A128GCM"
+const jweKeyWrapSize int = 16
+
+type jweHeader struct {
+	Alg string  `json:"alg"`
+	Enc string  `json:"enc"`
+	Kid string  `json:"kid,omitempty"`
+	Epk *jweEPK `json:"epk"`
+}
+
+// Ephemeral public key of the ECDH-ES key agreement, as a JWK.
+type jweEPK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Wraps document keys as compact JWE using ECDH-ES+A128KW key agreement and
+// A128GCM content encryption. The JWE plaintext is the same JSON
+// swgEncryptionKey wrapped by the other recipients.
+type JWERecipient struct {
+	domain string
+	pub    *ecdsa.PublicKey
+	kid    string
+}
+
+// Public function to create a JWERecipient for the domain. The key ID is
+// copied to the "kid" header so readers can pick their private key.
+func NewJWERecipient(domain string, pub *ecdsa.PublicKey, kid string) (*JWERecipient, error) {
+	if _, err := jweCurveName(pub.Curve); err != nil {
+		return nil, err
+	}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("The JWE recipient key is not on its curve.")
+	}
+	return &JWERecipient{domain: domain, pub: pub, kid: kid}, nil
+}
+
+func (r *JWERecipient) Domain() string {
+	return r.domain
+}
+
+func (r *JWERecipient) KeyID() string {
+	return r.kid
+}
+
+// Returns the compact JWE of the swgEncryptionKey.
+func (r *JWERecipient) WrapKey(docKey []byte, accessRequirements []string) (string, error) {
+	payload, err := newSwgEncryptionKeyPayload(docKey, accessRequirements)
+	if err != nil {
+		return "", err
+	}
+	return EncryptJWE(payload, r.pub, r.kid)
+}
+
+// Unwraps entries of a JWERecipient with the matching private key.
+type JWEUnwrapper struct {
+	priv *ecdsa.PrivateKey
+}
+
+// Public function to create a JWEUnwrapper from a private key.
+func NewJWEUnwrapper(priv *ecdsa.PrivateKey) *JWEUnwrapper {
+	return &JWEUnwrapper{priv: priv}
+}
+
+// The key ID of the private key is not known, so every entry is tried.
+func (u *JWEUnwrapper) HasKey(keyID string) bool {
+	return true
+}
+
+func (u *JWEUnwrapper) UnwrapKey(wrapped string) ([]byte, error) {
+	return DecryptJWE(wrapped, u.priv)
+}
+
+// Public function to encrypt the plaintext to the public key as a compact
+// ECDH-ES+A128KW / A128GCM JWE.
+func EncryptJWE(plaintext []byte, pub *ecdsa.PublicKey, kid string) (string, error) {
+	crv, err := jweCurveName(pub.Curve)
+	if err != nil {
+		return "", err
+	}
+	eph, err := ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	size := curveByteSize(pub.Curve)
+	header := jweHeader{
+		Alg: jweAlgECDHESA128KW,
+		Enc: jweEncA128GCM,
+		Kid: kid,
+		Epk: &jweEPK{
+			Kty: "EC",
+			Crv: crv,
+			X:   base64.RawURLEncoding.EncodeToString(fixedSizeBytes(eph.X, size)),
+			Y:   base64.RawURLEncoding.EncodeToString(fixedSizeBytes(eph.Y, size)),
+		},
+	}
+	hb, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	protected := base64.RawURLEncoding.EncodeToString(hb)
+	kek := jweDeriveKEK(pub.Curve, pub.X, pub.Y, eph.D)
+	cek := make([]byte, jweKeyWrapSize)
+	if _, err := rand.Read(cek); err != nil {
+		return "", err
+	}
+	encryptedKey, err := aesKeyWrap(kek, cek)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newAESGCM(cek)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, plaintext, []byte(protected))
+	ct, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return strings.Join([]string{
+		protected,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ct),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// Public function to decrypt a compact ECDH-ES+A128KW / A128GCM JWE with the
+// private key.
+func DecryptJWE(compact string, priv *ecdsa.PrivateKey) ([]byte, error) {
+	parts := strings.Split(compact, ".")
+	if len(parts) != 5 {
+		return nil, errors.New("Malformed compact JWE.")
+	}
+	var raw [5][]byte
+	for i, p := range parts {
+		b, err := base64.RawURLEncoding.DecodeString(p)
+		if err != nil {
+			return nil, err
+		}
+		raw[i] = b
+	}
+	var header jweHeader
+	if err := json.Unmarshal(raw[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != jweAlgECDHESA128KW || header.Enc != jweEncA128GCM {
+		return nil, errors.New("Unsupported JWE algorithm: " + header.Alg + " " + header.Enc)
+	}
+	if header.Epk == nil || header.Epk.Kty != "EC" {
+		return nil, errors.New("Missing JWE ephemeral public key.")
+	}
+	crv, err := jweCurveName(priv.Curve)
+	if err != nil {
+		return nil, err
+	}
+	if header.Epk.Crv != crv {
+		return nil, errors.New("JWE ephemeral key curve " + header.Epk.Crv + " does not match the private key.")
+	}
+	x, err := base64.RawURLEncoding.DecodeString(header.Epk.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(header.Epk.Y)
+	if err != nil {
+		return nil, err
+	}
+	ex, ey := new(big.Int).SetBytes(x), new(big.Int).SetBytes(y)
+	// Reject invalid points, which could leak the private key.
+	if !priv.Curve.IsOnCurve(ex, ey) {
+		return nil, errors.New("JWE ephemeral key is not on the curve.")
+	}
+	kek := jweDeriveKEK(priv.Curve, ex, ey, priv.D)
+	cek, err := aesKeyUnwrap(kek, raw[1])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newAESGCM(cek)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw[2]) != gcm.NonceSize() || len(raw[4]) != gcm.Overhead() {
+		return nil, errors.New("Malformed compact JWE.")
+	}
+	sealed := append(append([]byte{}, raw[3]...), raw[4]...)
+	return gcm.Open(nil, raw[2], sealed, []byte(parts[0]))
+}
+
+// Derives the key encryption key of ECDH-ES+A128KW. PartyUInfo and
+// PartyVInfo are empty.
+func jweDeriveKEK(curve elliptic.Curve, x *big.Int, y *big.Int, d *big.Int) []byte {
+	zx, _ := curve.ScalarMult(x, y, d.Bytes())
+	z := fixedSizeBytes(zx, curveByteSize(curve))
+	return concatKDF(z, jweAlgECDHESA128KW, nil, nil, jweKeyWrapSize)
+}
+
+// Derives a key of up to 32 bytes from the ECDH shared secret with the
+// Concat KDF of RFC 7518 section 4.6.2.
+func concatKDF(z []byte, alg string, apu []byte, apv []byte, keySize int) []byte {
+	keyDataLen := make([]byte, 4)
+	binary.BigEndian.PutUint32(keyDataLen, uint32(keySize*8))
+	// A single SHA-256 round covers the key.
+	h := sha256.New()
+	h.Write([]byte{0, 0, 0, 1})
+	h.Write(z)
+	h.Write(lengthPrefixed([]byte(alg)))
+	h.Write(lengthPrefixed(apu))
+	h.Write(lengthPrefixed(apv))
+	h.Write(keyDataLen)
+	return h.Sum(nil)[:keySize]
+}
+
+// Prefixes the input with its 32 bit big endian length.
+func lengthPrefixed(b []byte) []byte {
+	out := make([]byte, 4, 4+len(b))
+	binary.BigEndian.PutUint32(out, uint32(len(b)))
+	return append(out, b...)
+}
+
+// Default initial value of RFC 3394 AES key wrap.
+var aesKeyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// Wraps the key with the RFC 3394 AES key wrap algorithm.
+func aesKeyWrap(kek []byte, key []byte) ([]byte, error) {
+	if len(key)%8 != 0 || len(key) < 16 {
+		return nil, errors.New("Key to wrap must be a multiple of 8 bytes.")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(key) / 8
+	a := append([]byte{}, aesKeyWrapIV...)
+	r := append([]byte{}, key...)
+	buf := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 0; i < n; i++ {
+			copy(buf, a)
+			copy(buf[8:], r[i*8:i*8+8])
+			block.Encrypt(buf, buf)
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(buf[:8])^t)
+			copy(r[i*8:], buf[8:])
+		}
+	}
+	return append(a, r...), nil
+}
+
+// Unwraps a key wrapped with the RFC 3394 AES key wrap algorithm.
+func aesKeyUnwrap(kek []byte, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, errors.New("Malformed wrapped key.")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(wrapped)/8 - 1
+	a := append([]byte{}, wrapped[:8]...)
+	r := append([]byte{}, wrapped[8:]...)
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n - 1; i >= 0; i-- {
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(buf, binary.BigEndian.Uint64(a)^t)
+			copy(buf[8:], r[i*8:i*8+8])
+			block.Decrypt(buf, buf)
+			copy(a, buf[:8])
+			copy(r[i*8:], buf[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(a, aesKeyWrapIV) != 1 {
+		return nil, errors.New("Failed to unwrap key.")
+	}
+	return r, nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns the JWK name of a NIST curve.
+func jweCurveName(curve elliptic.Curve) (string, error) {
+	switch curve.Params().Name {
+	case "P-256", "P-384", "P-521":
+		return curve.Params().Name, nil
+	}
+	return "", errors.New("Unsupported JWE curve: " + curve.Params().Name)
+}
+
+func curveByteSize(curve elliptic.Curve) int {
+	return (curve.Params().BitSize + 7) / 8
+}
+
+// Encodes n as a big endian integer of the input size.
+func fixedSizeBytes(n *big.Int, size int) []byte {
+	b := make([]byte, size)
+	nb := n.Bytes()
+	copy(b[size-len(nb):], nb)
+	return b
+}
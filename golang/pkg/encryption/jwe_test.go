@@ -0,0 +1,123 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func newTestECDSAKey(t *testing.T) *ecdsa.PrivateKey {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	return priv
+}
+
+func decodeTestBase64URL(t *testing.T, s string) *big.Int {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("Failed to decode %s: %v", s, err)
+	}
+	return new(big.Int).SetBytes(b)
+}
+
+// Test vector from RFC 3394 section 4.1.
+func TestAESKeyWrap(t *testing.T) {
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F")
+	key, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF")
+	want, _ := hex.DecodeString("1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5")
+	wrapped, err := aesKeyWrap(kek, key)
+	if err != nil {
+		t.Fatalf("Failed to wrap key: %v", err)
+	}
+	if !bytes.Equal(wrapped, want) {
+		t.Errorf("Wrapped key %x; want %x", wrapped, want)
+	}
+	unwrapped, err := aesKeyUnwrap(kek, wrapped)
+	if err != nil {
+		t.Fatalf("Failed to unwrap key: %v", err)
+	}
+	if !bytes.Equal(unwrapped, key) {
+		t.Errorf("Unwrapped key %x; want %x", unwrapped, key)
+	}
+	wrapped[0] ^= 1
+	if _, err := aesKeyUnwrap(kek, wrapped); err == nil {
+		t.Errorf("Expected failure unwrapping a modified key.")
+	}
+}
+
+// Test vector from RFC 7518 appendix C.
+func TestConcatKDF(t *testing.T) {
+	curve := elliptic.P256()
+	x := decodeTestBase64URL(t, "weNJy2HscCSM6AEDTDg04biOvhFhyyWvOHQfeF_PxMQ")
+	y := decodeTestBase64URL(t, "e8lnCO-AlStT-NJVX-crhB7QRYhiix03illJOVAOyck")
+	d := decodeTestBase64URL(t, "0_NxaRPUMQoAJt50Gz8YiTr8gRTwyEaCumd-MToTmIo")
+	zx, _ := curve.ScalarMult(x, y, d.Bytes())
+	key := concatKDF(fixedSizeBytes(zx, 32), "A128GCM", []byte("Alice"), []byte("Bob"), 16)
+	if got := base64.RawURLEncoding.EncodeToString(key); got != "VqqN6vgjbSBcIijNcacQGg" {
+		t.Errorf("Derived key %s; want VqqN6vgjbSBcIijNcacQGg", got)
+	}
+}
+
+func TestJWERecipientRoundTrip(t *testing.T) {
+	priv := newTestECDSAKey(t)
+	r, err := NewJWERecipient("partner.com", &priv.PublicKey, "key-1")
+	if err != nil {
+		t.Fatalf("Failed to create recipient: %v", err)
+	}
+	docKey := []byte("0123456789abcdef")
+	wrapped, err := r.WrapKey(docKey, []string{"norcal.com:premium"})
+	if err != nil {
+		t.Fatalf("Failed to wrap document key: %v", err)
+	}
+	if len(strings.Split(wrapped, ".")) != 5 {
+		t.Fatalf("Not a compact JWE: %s", wrapped)
+	}
+	checker := &StaticEntitlementChecker{Grants: map[string][]string{"reader": []string{"norcal.com:premium"}}}
+	key, err := UnwrapDocumentKey(wrapped, NewJWEUnwrapper(priv), "reader", checker)
+	if err != nil {
+		t.Fatalf("Failed to unwrap document key: %v", err)
+	}
+	if !bytes.Equal(key, docKey) {
+		t.Errorf("Unwrapped key %x; want %x", key, docKey)
+	}
+	if _, err := DecryptJWE(wrapped, newTestECDSAKey(t)); err == nil {
+		t.Errorf("Expected failure decrypting with another key.")
+	}
+}
+
+func TestDecryptJWERejectsModifiedHeader(t *testing.T) {
+	priv := newTestECDSAKey(t)
+	compact, err := EncryptJWE([]byte("payload"), &priv.PublicKey, "")
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	parts := strings.Split(compact, ".")
+	header, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	header = bytes.Replace(header, []byte(`"enc":"A128GCM"`), []byte(`"enc":"A128GCM","x":1`), 1)
+	parts[0] = base64.RawURLEncoding.EncodeToString(header)
+	if _, err := DecryptJWE(strings.Join(parts, "."), priv); err == nil {
+		t.Errorf("Expected failure on modified protected header.")
+	}
+}
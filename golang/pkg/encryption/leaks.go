@@ -0,0 +1,365 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Helper functions to find the plaintext of encrypted sections that is still
+// published elsewhere in the document, e.g. when a CMS copies the article
+// body into JSON-LD or meta descriptions. Each text field of the document is
+// split into shingles of consecutive words, and the field leaks if enough of
+// its shingles appear in the plaintext of the sections.
+
+// Default number of words per shingle.
+const DefaultLeakShingleSize int = 5
+
+// Default fraction of the shingles of a field found in the plaintext above
+// which the field leaks.
+const DefaultLeakThreshold float64 = 0.5
+
+// Options of the leak scan. The document is not scanned unless Report, Fail
+// or RedactLDJSON is set.
+type LeakOptions struct {
+	// Number of words per shingle, DefaultLeakShingleSize if zero. Fields
+	// with fewer words are not scanned.
+	ShingleSize int
+	// Fraction of the shingles of a field found in the plaintext above
+	// which the field leaks, DefaultLeakThreshold if zero.
+	Threshold float64
+	// Called with every leak found, including redacted ones.
+	Report func(Leak)
+	// Reject documents with leaks that are not redacted.
+	Fail bool
+	// Remove leaking string values from application/ld+json scripts.
+	RedactLDJSON bool
+}
+
+// Text of the document that matches the plaintext of encrypted sections.
+type Leak struct {
+	// Where the text was found, such as `meta name="description"` or
+	// `application/ld+json articleBody`.
+	Location string
+	// The leaking text.
+	Text string
+	// Fraction of the shingles of the text found in the plaintext.
+	Score float64
+	// Whether the text was removed from the document.
+	Redacted bool
+}
+
+// Returns true if the options ask for a scan.
+func (opts LeakOptions) enabled() bool {
+	return opts.Report != nil || opts.Fail || opts.RedactLDJSON
+}
+
+// Set of the shingles of the plaintext of encrypted sections.
+type shingleSet struct {
+	size     int
+	shingles map[string]bool
+}
+
+func newShingleSet(size int) *shingleSet {
+	if size <= 0 {
+		size = DefaultLeakShingleSize
+	}
+	return &shingleSet{size: size, shingles: make(map[string]bool)}
+}
+
+// Adds the shingles of the text to the set.
+func (s *shingleSet) add(text string) {
+	for _, sh := range shingles(text, s.size) {
+		s.shingles[sh] = true
+	}
+}
+
+// Returns the fraction of the shingles of the text in the set, or 0 if the
+// text has fewer words than a shingle.
+func (s *shingleSet) score(text string) float64 {
+	shs := shingles(text, s.size)
+	if len(shs) == 0 {
+		return 0
+	}
+	matches := 0
+	for _, sh := range shs {
+		if s.shingles[sh] {
+			matches++
+		}
+	}
+	return float64(matches) / float64(len(shs))
+}
+
+// Returns the shingles of size consecutive words of the text, ignoring case
+// and punctuation.
+func shingles(text string, size int) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	var shs []string
+	for i := 0; i+size <= len(words); i++ {
+		shs = append(shs, strings.Join(words[i:i+size], " "))
+	}
+	return shs
+}
+
+// Scans the document outside of encrypted sections and teasers for the
+// plaintext, redacting application/ld+json values if requested. Returns an
+// error if the options fail on a leak that was not redacted.
+func scanForLeaks(parsedHTML *html.Node, plaintext *shingleSet, opts LeakOptions) error {
+	threshold := opts.Threshold
+	if threshold <= 0 {
+		threshold = DefaultLeakThreshold
+	}
+	skip := make(map[*html.Node]bool)
+	for _, section := range getAllEncryptedSections(parsedHTML) {
+		skip[section] = true
+	}
+	var leaks []Leak
+	check := func(location string, text string) bool {
+		score := plaintext.score(text)
+		if score < threshold {
+			return false
+		}
+		leaks = append(leaks, Leak{Location: location, Text: text, Score: score})
+		return true
+	}
+	// Elements whose text is not part of the text around them.
+	separate := func(n *html.Node) bool {
+		return skip[n] || hasAttr(n, teaserAttr) || separateLeakElements[n.DataAtom] || teaserBlockElements[n.DataAtom] || hasBlockElement(n)
+	}
+	var walk func(n *html.Node) error
+	walk = func(n *html.Node) error {
+		if n.Type == html.ElementNode {
+			if skip[n] || hasAttr(n, teaserAttr) {
+				return nil
+			}
+			checkLeakAttrs(n, check)
+			switch {
+			case n.DataAtom == atom.Script:
+				if strings.EqualFold(getAttr(n, "type"), "application/ld+json") {
+					return scanLDJSON(n, check, opts.RedactLDJSON, &leaks)
+				}
+				return nil
+			case n.DataAtom == atom.Style || n.DataAtom == atom.Template:
+				return nil
+			case n.DataAtom == atom.Meta:
+				for _, key := range []string{"name", "property", "itemprop"} {
+					if hasAttr(n, key) {
+						check("meta "+key+"=\""+getAttr(n, key)+"\"", getAttr(n, "content"))
+						break
+					}
+				}
+				return nil
+			case n.DataAtom == atom.Noscript:
+				// With scripting enabled the parser keeps the markup of
+				// noscript as text.
+				check("<noscript>", markupText(textContent(n)))
+				return nil
+			case (n.DataAtom == atom.Title || teaserBlockElements[n.DataAtom]) && !hasBlockElement(n):
+				check("<"+n.Data+">", strings.Join(strings.Fields(textContent(n)), " "))
+				for c := n.FirstChild; c != nil; c = c.NextSibling {
+					checkLeakAttrsBelow(c, check)
+				}
+				return nil
+			}
+		}
+		// Text outside of the blocks of n is checked as one field per run of
+		// text and inline elements.
+		var run strings.Builder
+		flush := func() {
+			check("<"+n.Data+"> text", strings.Join(strings.Fields(run.String()), " "))
+			run.Reset()
+		}
+		var inline func(c *html.Node) error
+		inline = func(c *html.Node) error {
+			switch {
+			case c.Type == html.TextNode:
+				run.WriteString(c.Data)
+				return nil
+			case c.Type == html.ElementNode && !separate(c):
+				checkLeakAttrs(c, check)
+				for gc := c.FirstChild; gc != nil; gc = gc.NextSibling {
+					if err := inline(gc); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			flush()
+			return walk(c)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := inline(c); err != nil {
+				return err
+			}
+		}
+		flush()
+		return nil
+	}
+	if err := walk(parsedHTML); err != nil {
+		return err
+	}
+	var unredacted []string
+	for _, leak := range leaks {
+		if opts.Report != nil {
+			opts.Report(leak)
+		}
+		if !leak.Redacted {
+			unredacted = append(unredacted, leak.Location)
+		}
+	}
+	if opts.Fail && len(unredacted) > 0 {
+		return errors.New("Plaintext of encrypted sections leaks in: " + strings.Join(unredacted, ", ") + ".")
+	}
+	return nil
+}
+
+// Elements scanned apart from the text around them.
+var separateLeakElements = map[atom.Atom]bool{
+	atom.Meta: true, atom.Noscript: true, atom.Script: true, atom.Style: true,
+	atom.Template: true, atom.Title: true,
+}
+
+// Attributes holding text shown to readers.
+var leakAttrs = []string{"alt", "title", "aria-label"}
+
+// Checks the attributes of the element that hold text.
+func checkLeakAttrs(n *html.Node, check func(string, string) bool) {
+	for _, key := range leakAttrs {
+		if hasAttr(n, key) {
+			check(n.Data+" "+key, getAttr(n, key))
+		}
+	}
+}
+
+// Checks the attributes of n and of the elements below it that hold text.
+func checkLeakAttrsBelow(n *html.Node, check func(string, string) bool) {
+	if n.Type == html.ElementNode {
+		checkLeakAttrs(n, check)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		checkLeakAttrsBelow(c, check)
+	}
+}
+
+// Returns true if an element below n other than a line break holds a block of
+// text.
+func hasBlockElement(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && ((teaserBlockElements[c.DataAtom] && c.DataAtom != atom.Br) || hasBlockElement(c)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the string values of an application/ld+json script, removing the
+// leaking ones if redact is set. Scripts that are not valid JSON are checked
+// as a whole and never redacted.
+func scanLDJSON(script *html.Node, check func(string, string) bool, redact bool, leaks *[]Leak) error {
+	text := scriptText(script)
+	d := json.NewDecoder(strings.NewReader(text))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		check("application/ld+json", text)
+		return nil
+	}
+	first := len(*leaks)
+	v, _ = scanJSONValue(v, "", func(path string, s string) bool {
+		return check("application/ld+json "+path, markupText(s)) && redact
+	})
+	if !redact || len(*leaks) == first {
+		return nil
+	}
+	for i := first; i < len(*leaks); i++ {
+		(*leaks)[i].Redacted = true
+	}
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(v); err != nil {
+		return err
+	}
+	for c := script.FirstChild; c != nil; c = script.FirstChild {
+		script.RemoveChild(c)
+	}
+	script.AppendChild(&html.Node{Type: html.TextNode, Data: strings.TrimSpace(b.String())})
+	return nil
+}
+
+// Calls remove with the path of every string below v, and returns v without
+// the strings that it returned true for. The second return value is true if
+// v itself is removed.
+func scanJSONValue(v interface{}, path string, remove func(string, string) bool) (interface{}, bool) {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+	switch t := v.(type) {
+	case string:
+		return v, remove(path, t)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for key := range t {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if nv, removed := scanJSONValue(t[key], join(key), remove); removed {
+				delete(t, key)
+			} else {
+				t[key] = nv
+			}
+		}
+	case []interface{}:
+		kept := t[:0]
+		for i, e := range t {
+			if nv, removed := scanJSONValue(e, join(strconv.Itoa(i)), remove); !removed {
+				kept = append(kept, nv)
+			}
+		}
+		return kept, false
+	}
+	return v, false
+}
+
+// Returns the text of a string that may hold HTML markup, such as a JSON-LD
+// value.
+func markupText(s string) string {
+	if !strings.Contains(s, "<") {
+		return s
+	}
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(s), body)
+	if err != nil {
+		return s
+	}
+	var b strings.Builder
+	for _, n := range nodes {
+		b.WriteString(textContent(n))
+		b.WriteString(" ")
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
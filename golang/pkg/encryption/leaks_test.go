@@ -0,0 +1,161 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"encoding/json"
+	"golang.org/x/net/html"
+	"strings"
+	"testing"
+)
+
+const leakTestDocument = `<!doctype html><html ⚡><head>
+<title>Mayor resigns</title>
+<meta name="description" content="The mayor resigned on Monday after a long dispute over the budget.">
+<meta property="og:description" content="Read our exclusive story.">
+<script type="application/ld+json">{"@type":"NewsArticle","headline":"Mayor resigns","articleBody":"<p>The mayor resigned on Monday after a long dispute over the budget.</p>","author":[{"name":"A. Reporter"}]}</script>
+</head><body>
+<p>Subscribe for more stories like this one every single day.</p>
+<section subscriptions-section="content" encrypted><p>The mayor resigned on Monday after a long dispute over the budget. Council members were not surprised.</p></section>
+</body></html>`
+
+func TestShingleSetScore(t *testing.T) {
+	s := newShingleSet(3)
+	s.add("The mayor resigned on Monday.")
+	for _, tc := range []struct {
+		text string
+		want float64
+	}{
+		{"the MAYOR resigned, on monday", 1},
+		{"The mayor resigned on Friday", 2.0 / 3},
+		{"Nothing to see here", 0},
+		{"The mayor", 0},
+	} {
+		if got := s.score(tc.text); got != tc.want {
+			t.Errorf("Score of %q is %v; want %v", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestEncryptDocumentLeaks(t *testing.T) {
+	recipients, _ := newTestRecipient(t)
+	var leaks []Leak
+	opts := EncryptOptions{
+		Teaser: TeaserOptions{FirstParagraph: true},
+		Leaks:  LeakOptions{Report: func(l Leak) { leaks = append(leaks, l) }},
+	}
+	encDoc, err := GenerateEncryptedDocumentWithOptions(leakTestDocument, []string{"norcal.com:premium"}, recipients, opts)
+	if err != nil {
+		t.Fatalf("Failed to encrypt document: %v", err)
+	}
+	var locations []string
+	for _, l := range leaks {
+		locations = append(locations, l.Location)
+		if l.Redacted {
+			t.Errorf("Unexpected redaction of %s.", l.Location)
+		}
+	}
+	want := `meta name="description",application/ld+json articleBody`
+	if got := strings.Join(locations, ","); got != want {
+		t.Errorf("Leaks are %s; want %s", got, want)
+	}
+	if !strings.Contains(encDoc, `"articleBody"`) {
+		t.Errorf("Reporting removed articleBody: %s", encDoc)
+	}
+
+	opts.Leaks.Fail = true
+	if _, err := GenerateEncryptedDocumentWithOptions(leakTestDocument, []string{"norcal.com:premium"}, recipients, opts); err == nil {
+		t.Errorf("Expected failure on leaks.")
+	}
+	opts.Leaks.Threshold = 1.1
+	if _, err := GenerateEncryptedDocumentWithOptions(leakTestDocument, []string{"norcal.com:premium"}, recipients, opts); err != nil {
+		t.Errorf("Unexpected failure above any score: %v", err)
+	}
+
+	leaks = nil
+	opts.Leaks = LeakOptions{RedactLDJSON: true, Report: func(l Leak) { leaks = append(leaks, l) }}
+	redacted := strings.Replace(leakTestDocument, `<meta name="description" content="The mayor resigned on Monday after a long dispute over the budget.">`, "", 1)
+	encDoc, err = GenerateEncryptedDocumentWithOptions(redacted, []string{"norcal.com:premium"}, recipients, opts)
+	if err != nil {
+		t.Fatalf("Failed to encrypt document: %v", err)
+	}
+	if len(leaks) != 1 || !leaks[0].Redacted {
+		t.Fatalf("Leaks are %+v; want one redacted leak", leaks)
+	}
+	start := strings.Index(encDoc, `<script type="application/ld+json">`) + len(`<script type="application/ld+json">`)
+	end := strings.Index(encDoc[start:], "</script>")
+	var ld map[string]interface{}
+	if err := json.Unmarshal([]byte(encDoc[start:start+end]), &ld); err != nil {
+		t.Fatalf("Failed to parse redacted JSON-LD: %v", err)
+	}
+	if _, ok := ld["articleBody"]; ok || ld["headline"] != "Mayor resigns" || ld["author"] == nil {
+		t.Errorf("Wrong redacted JSON-LD: %v", ld)
+	}
+	opts.Leaks.Fail = true
+	if _, err := GenerateEncryptedDocumentWithOptions(redacted, []string{"norcal.com:premium"}, recipients, opts); err != nil {
+		t.Errorf("Unexpected failure on redacted leaks: %v", err)
+	}
+}
+
+func TestScanForLeaksLocations(t *testing.T) {
+	const leaking = "The mayor resigned on Monday after a long dispute over the budget."
+	tests := []struct {
+		name     string
+		body     string
+		location string
+	}{
+		{"body text", `Intro. ` + leaking + `<p>Other.</p>`, "<body> text"},
+		{"div text", `<div><b>` + leaking + `</b><p>Other.</p></div>`, "<div> text"},
+		{"img alt", `<img alt="` + leaking + `">`, "img alt"},
+		{"title attribute", `<p>Caption <a title="` + leaking + `">link</a></p>`, "a title"},
+		{"aria-label", `<div aria-label="` + leaking + `"><p>Other.</p></div>`, "div aria-label"},
+		{"noscript", `<noscript><p>` + leaking + `</p></noscript>`, "<noscript>"},
+	}
+	plaintext := newShingleSet(0)
+	plaintext.add(leaking)
+	for _, test := range tests {
+		parsedHTML, err := html.Parse(strings.NewReader("<!doctype html><html><head></head><body>" + test.body + "</body></html>"))
+		if err != nil {
+			t.Fatalf("%s: failed to parse document: %v", test.name, err)
+		}
+		var locations []string
+		opts := LeakOptions{Report: func(l Leak) { locations = append(locations, l.Location) }}
+		if err := scanForLeaks(parsedHTML, plaintext, opts); err != nil {
+			t.Fatalf("%s: failed to scan document: %v", test.name, err)
+		}
+		if got := strings.Join(locations, ","); got != test.location {
+			t.Errorf("%s: leaks are %s; want %s", test.name, got, test.location)
+		}
+	}
+}
+
+func TestScanJSONValue(t *testing.T) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(`{"a":"x","b":["x","y",{"c":"x"}],"d":1}`), &v); err != nil {
+		t.Fatalf("Failed to parse JSON: %v", err)
+	}
+	var paths []string
+	v, _ = scanJSONValue(v, "", func(path string, s string) bool {
+		paths = append(paths, path)
+		return s == "x"
+	})
+	if got := strings.Join(paths, ","); got != "a,b.0,b.1,b.2.c" {
+		t.Errorf("Paths are %s", got)
+	}
+	b, _ := json.Marshal(v)
+	if string(b) != `{"b":["y",{}],"d":1}` {
+		t.Errorf("Redacted JSON is %s", b)
+	}
+}
@@ -0,0 +1,354 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/google/tink/go/tink"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Helper functions to protect the images of encrypted sections, which would
+// otherwise stay fetchable from their public URLs.
+
+// How GenerateEncryptedDocumentWithOptions handles the images of encrypted
+// sections.
+type MediaMode int
+
+const (
+	// Leave image sources as they are.
+	MediaUnchanged MediaMode = iota
+	// Replace local image sources with data URIs inside the encrypted
+	// content.
+	MediaInline
+	// Encrypt local and data URI images as separate ciphertext blobs under
+	// the document key, referenced from the encrypted content.
+	MediaBlobs
+)
+
+// Largest image read by default, in bytes.
+const defaultMaxMediaSize int64 = 8 << 20
+
+// Attributes referencing a ciphertext blob from an image of the encrypted
+// content, and the blob script from its section.
+const (
+	blobRefAttr    string = "data-swg-blob"
+	blobTypeAttr   string = "data-swg-blob-type"
+	blobScriptAttr string = "ciphertext-blob"
+)
+
+// Options of the images of encrypted sections.
+type MediaOptions struct {
+	Mode MediaMode
+	// Directory that local image sources are resolved in, as the root of
+	// the site. Sources can not refer to files outside of it, whether
+	// through ".." segments or symbolic links.
+	Dir string
+	// Largest image read, in bytes. Defaults to 8 MiB.
+	MaxSize int64
+}
+
+// An image encrypted separately from the section content.
+type mediaBlob struct {
+	id   string
+	data []byte
+}
+
+// Rewrites the sources of the images below the section according to the
+// options, and returns the images to encrypt as blobs. Remote images are left
+// as they are. Blob IDs are numbered from *nextID, unique in the document.
+func prepareMedia(section *html.Node, opts MediaOptions, nextID *int) ([]mediaBlob, error) {
+	if opts.Mode == MediaUnchanged {
+		return nil, nil
+	}
+	var blobs []mediaBlob
+	for _, img := range findImages(section) {
+		src := getAttr(img, "src")
+		if opts.Mode == MediaInline && strings.HasPrefix(src, "data:") {
+			continue
+		}
+		data, mimeType, err := loadMedia(src, opts)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		// Browsers would fetch the public candidates of a srcset instead.
+		removeAttr(img, "srcset")
+		if opts.Mode == MediaInline {
+			setAttr(img, "src", "data:"+mimeType+";base64,"+base64.StdEncoding.EncodeToString(data))
+			continue
+		}
+		*nextID++
+		id := fmt.Sprint(*nextID)
+		removeAttr(img, "src")
+		setAttr(img, blobRefAttr, id)
+		setAttr(img, blobTypeAttr, mimeType)
+		blobs = append(blobs, mediaBlob{id: id, data: data})
+	}
+	return blobs, nil
+}
+
+// Reads the image of a source attribute. Returns no data for remote sources.
+func loadMedia(src string, opts MediaOptions) ([]byte, string, error) {
+	maxSize := opts.MaxSize
+	if maxSize == 0 {
+		maxSize = defaultMaxMediaSize
+	}
+	if strings.HasPrefix(src, "data:") {
+		data, mimeType, err := parseDataURI(src)
+		if err != nil {
+			return nil, "", err
+		}
+		if int64(len(data)) > maxSize {
+			return nil, "", errors.New("Data URI image exceeds the size limit.")
+		}
+		return data, mimeType, nil
+	}
+	u, err := url.Parse(src)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return nil, "", nil
+	}
+	if opts.Dir == "" {
+		return nil, "", errors.New("No media directory to read image " + src + " from.")
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == ".." {
+			return nil, "", errors.New("Image " + src + " refers to a parent directory.")
+		}
+	}
+	file, err := resolveMediaFile(opts.Dir, u.Path)
+	if err != nil {
+		return nil, "", errors.New("Image " + src + ": " + err.Error())
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, "", err
+	}
+	if fi.Size() > maxSize {
+		return nil, "", errors.New("Image " + src + " exceeds the size limit.")
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, "", err
+	}
+	mimeType := mime.TypeByExtension(path.Ext(u.Path))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	mimeType = strings.TrimSpace(strings.Split(mimeType, ";")[0])
+	if !strings.HasPrefix(mimeType, "image/") {
+		return nil, "", errors.New("Source " + src + " is not an image.")
+	}
+	return data, mimeType, nil
+}
+
+// Returns the file of a URL path in the media directory, following symbolic
+// links. Fails if the file is outside of the directory.
+func resolveMediaFile(dir string, urlPath string) (string, error) {
+	base, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	file, err := filepath.EvalSymlinks(filepath.Join(base, filepath.FromSlash(path.Clean("/"+urlPath))))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(base, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New("The file is outside of the media directory.")
+	}
+	return file, nil
+}
+
+// Parses a data URI into its data and media type.
+func parseDataURI(uri string) ([]byte, string, error) {
+	i := strings.Index(uri, ",")
+	if i < 0 {
+		return nil, "", errors.New("Malformed data URI.")
+	}
+	meta, payload := uri[len("data:"):i], uri[i+1:]
+	isBase64 := strings.HasSuffix(meta, ";base64")
+	mimeType := strings.Split(strings.TrimSuffix(meta, ";base64"), ";")[0]
+	if mimeType == "" {
+		mimeType = "text/plain"
+	}
+	if isBase64 {
+		data, err := base64.StdEncoding.DecodeString(payload)
+		return data, mimeType, err
+	}
+	data, err := url.PathUnescape(payload)
+	return []byte(data), mimeType, err
+}
+
+// Encrypts the blobs with the document key and appends them to the section.
+// The blob ID is bound as associated data, so blobs can not be swapped. Blobs
+// are padded like the section content, and stored in the sink if set.
+func appendMediaBlobs(section *html.Node, blobs []mediaBlob, cipher tink.AEAD, params ContentParams, sink CiphertextSink) error {
+	for _, blob := range blobs {
+		var padded bytes.Buffer
+		pw, err := newPadWriter(&padded, params.Pad, params.PadQuantum)
+		if err != nil {
+			return err
+		}
+		if _, err := pw.Write(blob.data); err != nil {
+			return err
+		}
+		if err := pw.Close(); err != nil {
+			return err
+		}
+		ct, err := cipher.Encrypt(padded.Bytes(), []byte(blob.id))
+		if err != nil {
+			return err
+		}
+		var script *html.Node
+		if sink != nil {
+			if script, err = externalizeCiphertext(ct, sink); err != nil {
+				return err
+			}
+		} else {
+			script = &html.Node{
+				Type:     html.ElementNode,
+				Data:     "script",
+				DataAtom: atom.Script,
+				Attr: []html.Attribute{
+					html.Attribute{Key: "type", Val: "application/octet-stream"},
+				},
+			}
+			script.AppendChild(&html.Node{Type: html.TextNode, Data: base64.StdEncoding.EncodeToString(ct)})
+		}
+		setAttr(script, blobScriptAttr, blob.id)
+		section.AppendChild(script)
+	}
+	return nil
+}
+
+// Decrypts the blobs of the section into data URI sources of the images
+// below the decrypted nodes, and removes the blob scripts. Externalized blobs
+// are fetched from the source.
+func restoreMediaBlobs(section *html.Node, nodes []*html.Node, cipher tink.AEAD, params ContentParams, source CiphertextSource) error {
+	blobs := make(map[string]*html.Node)
+	for c := section.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Script && hasAttr(c, blobScriptAttr) {
+			blobs[getAttr(c, blobScriptAttr)] = c
+		}
+	}
+	for _, n := range nodes {
+		for _, img := range findImages(n) {
+			if !hasAttr(img, blobRefAttr) {
+				continue
+			}
+			id := getAttr(img, blobRefAttr)
+			script, ok := blobs[id]
+			if !ok {
+				return errors.New("Missing ciphertext blob " + id + ".")
+			}
+			var ct []byte
+			var err error
+			if hasAttr(script, ciphertextSrcAttr) {
+				ct, err = fetchCiphertext(script, source)
+			} else {
+				ct, err = base64.StdEncoding.DecodeString(scriptText(script))
+			}
+			if err != nil {
+				return err
+			}
+			padded, err := cipher.Decrypt(ct, []byte(id))
+			if err != nil {
+				return err
+			}
+			r, err := newUnpadReader(bytes.NewReader(padded), params.Pad)
+			if err != nil {
+				return err
+			}
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			setAttr(img, "src", "data:"+getAttr(img, blobTypeAttr)+";base64,"+base64.StdEncoding.EncodeToString(data))
+			removeAttr(img, blobRefAttr)
+			removeAttr(img, blobTypeAttr)
+		}
+	}
+	for _, script := range blobs {
+		section.RemoveChild(script)
+	}
+	return nil
+}
+
+// Returns the img and amp-img elements at or below n.
+func findImages(n *html.Node) []*html.Node {
+	var images []*html.Node
+	if n.Type == html.ElementNode && (n.DataAtom == atom.Img || n.Data == "amp-img") {
+		images = append(images, n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		images = append(images, findImages(c)...)
+	}
+	return images
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key string, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func removeAttr(n *html.Node, key string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+			return
+		}
+	}
+}
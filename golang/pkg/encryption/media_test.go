@@ -0,0 +1,224 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testImage = []byte("\x89PNG\r\n\x1a\nnot really a png")
+
+const mediaTestHTML = `<!doctype html><html ⚡><head></head><body>
+<amp-img src="https://cdn.example.com/public.png" width="1" height="1"></amp-img>
+<section subscriptions-section="content" encrypted>
+<p>Premium chart:</p>
+<amp-img src="/charts/chart.png?v=2" srcset="https://cdn.example.com/chart-2x.png 2x" width="1" height="1"></amp-img>
+<img src="data:image/gif;base64,R0lGODlhAQABAAAAACw=">
+<img src="https://cdn.example.com/remote.png">
+</section>
+</body></html>`
+
+// Creates a media directory holding the test image as charts/chart.png.
+func newTestMediaDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "media")
+	if err != nil {
+		t.Fatalf("Failed to create media directory: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "charts"), 0755); err != nil {
+		t.Fatalf("Failed to create media directory: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "charts", "chart.png"), testImage, 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+	return dir
+}
+
+func TestParseDataURI(t *testing.T) {
+	data, mimeType, err := parseDataURI("data:image/png;base64,aGk=")
+	if err != nil || string(data) != "hi" || mimeType != "image/png" {
+		t.Errorf("Got %q, %q, %v; want hi, image/png", data, mimeType, err)
+	}
+	data, mimeType, err = parseDataURI("data:,a%20b")
+	if err != nil || string(data) != "a b" || mimeType != "text/plain" {
+		t.Errorf("Got %q, %q, %v; want a b, text/plain", data, mimeType, err)
+	}
+	if _, _, err := parseDataURI("data:image/png;base64"); err == nil {
+		t.Errorf("Expected failure on data URI without data.")
+	}
+}
+
+func TestLoadMedia(t *testing.T) {
+	dir := newTestMediaDir(t)
+	defer os.RemoveAll(dir)
+	opts := MediaOptions{Mode: MediaInline, Dir: dir}
+	if err := os.Symlink(filepath.Join(dir, "charts", "chart.png"), filepath.Join(dir, "chart-link.png")); err != nil {
+		t.Fatalf("Failed to link image: %v", err)
+	}
+	for _, src := range []string{"charts/chart.png", "/charts/chart.png#x", "chart-link.png"} {
+		data, mimeType, err := loadMedia(src, opts)
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", src, err)
+		}
+		if !bytes.Equal(data, testImage) || mimeType != "image/png" {
+			t.Errorf("Loaded %s as %q, %s", src, data, mimeType)
+		}
+	}
+	for _, src := range []string{"https://example.com/a.png", "//example.com/a.png", ""} {
+		if data, _, err := loadMedia(src, opts); data != nil || err != nil {
+			t.Errorf("Expected remote source %q to be left, got %v", src, err)
+		}
+	}
+	if _, _, err := loadMedia("charts/missing.png", opts); err == nil {
+		t.Errorf("Expected failure on missing image.")
+	}
+	outside := newTestMediaDir(t)
+	defer os.RemoveAll(outside)
+	if err := os.Symlink(filepath.Join(outside, "charts", "chart.png"), filepath.Join(dir, "charts", "leak.png")); err != nil {
+		t.Fatalf("Failed to link image: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "charts"), filepath.Join(dir, "shared")); err != nil {
+		t.Fatalf("Failed to link directory: %v", err)
+	}
+	for _, src := range []string{"../../charts/chart.png", "charts/../../charts/chart.png", "charts/leak.png", "shared/chart.png"} {
+		if _, _, err := loadMedia(src, opts); err == nil {
+			t.Errorf("Expected failure on image %s outside of the media directory.", src)
+		}
+	}
+	if _, _, err := loadMedia("charts/chart.png", MediaOptions{Mode: MediaInline, Dir: dir, MaxSize: 4}); err == nil {
+		t.Errorf("Expected failure on oversized image.")
+	}
+}
+
+func TestEncryptDocumentMedia(t *testing.T) {
+	dir := newTestMediaDir(t)
+	defer os.RemoveAll(dir)
+	recipients, u := newTestRecipient(t)
+	wantSrc := `src="data:image/png;base64,` + base64.StdEncoding.EncodeToString(testImage) + `"`
+	for _, mode := range []MediaMode{MediaInline, MediaBlobs} {
+		opts := EncryptOptions{Media: MediaOptions{Mode: mode, Dir: dir}}
+		encDoc, err := GenerateEncryptedDocumentWithOptions(mediaTestHTML, []string{"norcal.com:premium"}, recipients, opts)
+		if err != nil {
+			t.Fatalf("Failed to encrypt document: %v", err)
+		}
+		if strings.Contains(encDoc, "chart") || !strings.Contains(encDoc, "public.png") {
+			t.Errorf("Unexpected image references: %s", encDoc)
+		}
+		if n := strings.Count(encDoc, blobScriptAttr); (mode == MediaBlobs) != (n == 2) {
+			t.Errorf("Got %d blobs in mode %d", n, mode)
+		}
+		decDoc, err := DecryptDocument(encDoc, "local", u, "reader", testChecker)
+		if err != nil {
+			t.Fatalf("Failed to decrypt document: %v", err)
+		}
+		if !strings.Contains(decDoc, wantSrc) || strings.Contains(decDoc, "srcset") {
+			t.Errorf("Decrypted document misses the inlined image: %s", decDoc)
+		}
+		if !strings.Contains(decDoc, `src="data:image/gif;base64,R0lGODlhAQABAAAAACw="`) || !strings.Contains(decDoc, "remote.png") {
+			t.Errorf("Decrypted document misses the other images: %s", decDoc)
+		}
+		if strings.Contains(decDoc, "data-swg-blob") || strings.Contains(decDoc, blobScriptAttr) {
+			t.Errorf("Decrypted document still references blobs: %s", decDoc)
+		}
+		if mode == MediaBlobs {
+			swapped := strings.Replace(strings.Replace(encDoc, `ciphertext-blob="1"`, `ciphertext-blob="x"`, 1), `ciphertext-blob="2"`, `ciphertext-blob="1"`, 1)
+			swapped = strings.Replace(swapped, `ciphertext-blob="x"`, `ciphertext-blob="2"`, 1)
+			if _, err := DecryptDocument(swapped, "local", u, "reader", testChecker); err == nil {
+				t.Errorf("Expected failure on swapped blobs.")
+			}
+		}
+	}
+}
+
+func TestEncryptDocumentMediaPaddedExternal(t *testing.T) {
+	dir := newTestMediaDir(t)
+	defer os.RemoveAll(dir)
+	ctDir, err := ioutil.TempDir("", "ciphertext")
+	if err != nil {
+		t.Fatalf("Failed to create ciphertext directory: %v", err)
+	}
+	defer os.RemoveAll(ctDir)
+	recipients, u := newTestRecipient(t)
+	opts := EncryptOptions{
+		CryptoKeysVersion: CryptoKeysV2,
+		Content:           ContentParams{Pad: PadQuantum, PadQuantum: 1024},
+		Media:             MediaOptions{Mode: MediaBlobs, Dir: dir},
+	}
+	encDoc, err := GenerateEncryptedDocumentWithOptions(mediaTestHTML, []string{"norcal.com:premium"}, recipients, opts)
+	if err != nil {
+		t.Fatalf("Failed to encrypt document: %v", err)
+	}
+	// The images differ in size, but their padded blobs do not.
+	parsedHTML, err := html.Parse(strings.NewReader(encDoc))
+	if err != nil {
+		t.Fatalf("Failed to parse document: %v", err)
+	}
+	var sizes []int
+	for _, id := range []string{"1", "2"} {
+		script := findScriptWithValue(parsedHTML, blobScriptAttr, id)
+		if script == nil {
+			t.Fatalf("Missing blob %s.", id)
+		}
+		sizes = append(sizes, len(scriptText(script)))
+	}
+	if sizes[0] != sizes[1] {
+		t.Errorf("Padded blobs have sizes %v", sizes)
+	}
+	if _, err := DecryptDocument(encDoc, "local", u, "reader", testChecker); err != nil {
+		t.Errorf("Failed to decrypt padded document: %v", err)
+	}
+
+	source := &CiphertextDir{Dir: ctDir, URLPrefix: "/ct/"}
+	opts.External = source
+	encDoc, err = GenerateEncryptedDocumentWithOptions(mediaTestHTML, []string{"norcal.com:premium"}, recipients, opts)
+	if err != nil {
+		t.Fatalf("Failed to encrypt document: %v", err)
+	}
+	if n := strings.Count(encDoc, `ciphertext-src="/ct/`); n != 3 {
+		t.Errorf("Got %d reference scripts; want 3", n)
+	}
+	files, err := filepath.Glob(filepath.Join(ctDir, "*.bin"))
+	if err != nil || len(files) != 3 {
+		t.Errorf("Got ciphertext files %v; want 3", files)
+	}
+	decDoc, err := DecryptDocumentWithOptions(encDoc, "local", u, "reader", testChecker, DecryptOptions{Ciphertexts: source})
+	if err != nil {
+		t.Fatalf("Failed to decrypt document: %v", err)
+	}
+	wantSrc := `src="data:image/png;base64,` + base64.StdEncoding.EncodeToString(testImage) + `"`
+	if !strings.Contains(decDoc, wantSrc) || strings.Contains(decDoc, blobScriptAttr) {
+		t.Errorf("Decrypted document misses the external image: %s", decDoc)
+	}
+}
+
+// Returns the first script at or below n whose attribute has the value.
+func findScriptWithValue(n *html.Node, attr string, val string) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Script && hasAttr(n, attr) && getAttr(n, attr) == val {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findScriptWithValue(c, attr, val); found != nil {
+			return found
+		}
+	}
+	return nil
+}
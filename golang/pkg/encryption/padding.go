@@ -0,0 +1,161 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"errors"
+	"io"
+)
+
+// Helper functions to hide the length of section content by padding it
+// before encryption.
+
+// Padding schemes of the section plaintext, recorded as "pad" in version 2
+// cryptokeys scripts. PadPowerOfTwo pads to the next power of two of at least
+// minPaddedSize bytes, PadQuantum to the next multiple of the quantum.
+const (
+	PadPowerOfTwo string = "pow2"
+	PadQuantum    string = "quantum"
+)
+
+// Smallest bucket of PadPowerOfTwo.
+const minPaddedSize int64 = 256
+
+// Largest quantum of PadQuantum accepted, bounding the padding added to each
+// section.
+const MaxPadQuantum int = 1 << 20
+
+// Pads the content written to it to its bucket on Close. As in ISO/IEC
+// 7816-4, a 0x80 byte marks the end of the content and is followed by zero
+// bytes, so the padding can be stripped unambiguously whatever the content.
+type padWriter struct {
+	w       io.Writer
+	pad     string
+	quantum int64
+	n       int64
+}
+
+// Creates a padWriter writing to w, or passes writes through if pad is
+// empty.
+func newPadWriter(w io.Writer, pad string, quantum int) (io.WriteCloser, error) {
+	switch pad {
+	case "":
+		return nopWriteCloser{w}, nil
+	case PadPowerOfTwo:
+	case PadQuantum:
+		if quantum <= 0 || quantum > MaxPadQuantum {
+			return nil, errors.New("Unsupported padding quantum.")
+		}
+	default:
+		return nil, errors.New("Unsupported padding: " + pad)
+	}
+	return &padWriter{w: w, pad: pad, quantum: int64(quantum)}, nil
+}
+
+func (p *padWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.n += int64(n)
+	return n, err
+}
+
+// Writes the end marker and the zero bytes up to the bucket size.
+func (p *padWriter) Close() error {
+	n := p.n + 1
+	size := minPaddedSize
+	if p.pad == PadQuantum {
+		size = (n + p.quantum - 1) / p.quantum * p.quantum
+	} else {
+		for size < n {
+			size *= 2
+		}
+	}
+	if _, err := p.w.Write([]byte{0x80}); err != nil {
+		return err
+	}
+	zeros := make([]byte, 4096)
+	for left := size - n; left > 0; left -= int64(len(zeros)) {
+		if left < int64(len(zeros)) {
+			zeros = zeros[:left]
+		}
+		if _, err := p.w.Write(zeros); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Strips the padding written by padWriter from the content read from r. A
+// 0x80 byte followed by zero bytes is held back until a non-zero byte shows
+// it to be content, so only the count of trailing zero bytes is buffered.
+type unpadReader struct {
+	r      io.Reader
+	buf    []byte
+	out    []byte
+	marker bool
+	zeros  int
+	err    error
+}
+
+// Creates an unpadReader reading from r, or returns r if pad is empty.
+func newUnpadReader(r io.Reader, pad string) (io.Reader, error) {
+	switch pad {
+	case "":
+		return r, nil
+	case PadPowerOfTwo, PadQuantum:
+		return &unpadReader{r: r, buf: make([]byte, 4096)}, nil
+	}
+	return nil, errors.New("Unsupported padding: " + pad)
+}
+
+func (u *unpadReader) Read(p []byte) (int, error) {
+	for len(u.out) == 0 {
+		if u.err == io.EOF && !u.marker {
+			return 0, errors.New("Malformed padding.")
+		}
+		if u.err != nil {
+			return 0, u.err
+		}
+		n, err := u.r.Read(u.buf)
+		for _, c := range u.buf[:n] {
+			if u.marker && c == 0 {
+				u.zeros++
+				continue
+			}
+			if u.marker {
+				u.out = append(u.out, 0x80)
+				u.out = append(u.out, make([]byte, u.zeros)...)
+				u.marker, u.zeros = false, 0
+			}
+			if c == 0x80 {
+				u.marker = true
+			} else {
+				u.out = append(u.out, c)
+			}
+		}
+		u.err = err
+	}
+	n := copy(p, u.out)
+	u.out = u.out[n:]
+	return n, nil
+}
+
+// Adds a no-op Close to a writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
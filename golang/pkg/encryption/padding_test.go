@@ -0,0 +1,107 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+// Pads the input content through a pad writer.
+func padTestContent(t *testing.T, content []byte, pad string, quantum int) []byte {
+	var buf bytes.Buffer
+	w, err := newPadWriter(&buf, pad, quantum)
+	if err != nil {
+		t.Fatalf("Failed to create pad writer: %v", err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatalf("Failed to pad: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to pad: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// Strips the padding through an unpad reader reading one byte at a time.
+func unpadTestContent(b []byte, pad string) ([]byte, error) {
+	r, err := newUnpadReader(iotest.OneByteReader(bytes.NewReader(b)), pad)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestPadSectionBuckets(t *testing.T) {
+	for _, tc := range []struct {
+		n       int
+		pad     string
+		quantum int
+		want    int
+	}{
+		{0, PadPowerOfTwo, 0, 256},
+		{255, PadPowerOfTwo, 0, 256},
+		{256, PadPowerOfTwo, 0, 512},
+		{3000, PadPowerOfTwo, 0, 4096},
+		{0, PadQuantum, 1000, 1000},
+		{999, PadQuantum, 1000, 1000},
+		{1000, PadQuantum, 1000, 2000},
+		{10000, PadQuantum, 5000, 15000},
+	} {
+		// Content ending in the marker and zero bytes must survive the padding.
+		content := bytes.Repeat([]byte{0x80, 0x00}, tc.n/2)
+		if tc.n%2 == 1 {
+			content = append(content, 0x80)
+		}
+		padded := padTestContent(t, content, tc.pad, tc.quantum)
+		if len(padded) != tc.want {
+			t.Errorf("Padded %d bytes with %s to %d; want %d", tc.n, tc.pad, len(padded), tc.want)
+		}
+		unpadded, err := unpadTestContent(padded, tc.pad)
+		if err != nil {
+			t.Fatalf("Failed to strip padding: %v", err)
+		}
+		if !bytes.Equal(unpadded, content) {
+			t.Errorf("Round trip of %d bytes with %s changed the content.", tc.n, tc.pad)
+		}
+	}
+}
+
+func TestUnpadSectionMalformed(t *testing.T) {
+	for _, b := range [][]byte{{}, {0x00, 0x00}, {'a', 0x01, 0x00}} {
+		if _, err := unpadTestContent(b, PadPowerOfTwo); err == nil {
+			t.Errorf("Expected failure on malformed padding %x.", b)
+		}
+	}
+	if _, err := newPadWriter(ioutil.Discard, PadQuantum, 0); err == nil {
+		t.Errorf("Expected failure on zero quantum.")
+	}
+	if _, err := newPadWriter(ioutil.Discard, PadQuantum, MaxPadQuantum+1); err == nil {
+		t.Errorf("Expected failure on huge quantum.")
+	}
+}
+
+func TestParseCryptoKeysPadQuantum(t *testing.T) {
+	if _, err := ParseCryptoKeys(`{"v":2,"alg":"A128GCM","pad":"quantum","padq":4096,"keys":{}}`); err != nil {
+		t.Errorf("Failed to parse padding quantum: %v", err)
+	}
+	for _, padq := range []string{"0", "-1", "1048577"} {
+		if _, err := ParseCryptoKeys(`{"v":2,"alg":"A128GCM","pad":"quantum","padq":` + padq + `,"keys":{}}`); err == nil {
+			t.Errorf("Expected failure on padding quantum %s.", padq)
+		}
+	}
+}
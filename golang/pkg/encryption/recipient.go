@@ -0,0 +1,254 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/google/tink/go/hybrid"
+	"github.com/google/tink/go/keyset"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"github.com/google/tink/go/tink"
+	"sort"
+	"strconv"
+)
+
+// Helper types to wrap document keys for the domains listed in the
+// cryptokeys script, and to unwrap them again.
+
+// Wraps document keys for one domain of the cryptokeys script. Several
+// recipients may share a domain, such as the outgoing and the incoming key
+// during a key rotation.
+type Recipient interface {
+	// Domain the wrapped key is listed under, such as "google.com".
+	Domain() string
+	// ID of the key wrapping the document key, or empty if there is only
+	// ever one key for the domain.
+	KeyID() string
+	// Wraps the document key along with the access requirements granted upon
+	// unwrapping, and returns the entry for the cryptokeys script.
+	WrapKey(docKey []byte, accessRequirements []string) (string, error)
+}
+
+// Unwraps cryptokeys entries written by a Recipient.
+type KeyUnwrapper interface {
+	// Returns false if entries wrapped under the key ID can't be unwrapped.
+	HasKey(keyID string) bool
+	// Returns the JSON encoded swgEncryptionKey the entry was wrapped from.
+	UnwrapKey(wrapped string) ([]byte, error)
+}
+
+// Wraps document keys with a Tink Hybrid public keyset. This is the format
+// read by Google.
+type TinkHybridRecipient struct {
+	domain string
+	keyID  string
+	he     tink.HybridEncrypt
+}
+
+// Public function to create a TinkHybridRecipient for the domain.
+func NewTinkHybridRecipient(domain string, pubKey tinkpb.Keyset) (*TinkHybridRecipient, error) {
+	handle, err := keyset.NewHandleWithNoSecrets(&pubKey)
+	if err != nil {
+		return nil, err
+	}
+	he, err := hybrid.NewHybridEncrypt(handle)
+	if err != nil {
+		return nil, err
+	}
+	keyID := strconv.FormatUint(uint64(pubKey.PrimaryKeyId), 10)
+	return &TinkHybridRecipient{domain: domain, keyID: keyID, he: he}, nil
+}
+
+func (r *TinkHybridRecipient) Domain() string {
+	return r.domain
+}
+
+// Returns the primary key ID of the public keyset.
+func (r *TinkHybridRecipient) KeyID() string {
+	return r.keyID
+}
+
+// Returns the base64 encoded hybrid ciphertext of the swgEncryptionKey.
+func (r *TinkHybridRecipient) WrapKey(docKey []byte, accessRequirements []string) (string, error) {
+	payload, err := newSwgEncryptionKeyPayload(docKey, accessRequirements)
+	if err != nil {
+		return "", err
+	}
+	enc, err := r.he.Encrypt(payload, nil)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(enc), nil
+}
+
+// Unwraps entries of a TinkHybridRecipient with the private keyset.
+type TinkHybridUnwrapper struct {
+	hd     tink.HybridDecrypt
+	keyIDs map[string]bool
+}
+
+// Public function to create a TinkHybridUnwrapper from a private keyset.
+func NewTinkHybridUnwrapper(privKh *keyset.Handle) (*TinkHybridUnwrapper, error) {
+	hd, err := hybrid.NewHybridDecrypt(privKh)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := privKh.Public()
+	if err != nil {
+		return nil, err
+	}
+	mem := &keyset.MemReaderWriter{}
+	if err := pub.WriteWithNoSecrets(mem); err != nil {
+		return nil, err
+	}
+	u := &TinkHybridUnwrapper{hd: hd, keyIDs: make(map[string]bool)}
+	for _, k := range mem.Keyset.Key {
+		u.keyIDs[strconv.FormatUint(uint64(k.KeyId), 10)] = true
+	}
+	return u, nil
+}
+
+// Returns true if the private keyset holds the key ID.
+func (u *TinkHybridUnwrapper) HasKey(keyID string) bool {
+	return u.keyIDs[keyID]
+}
+
+func (u *TinkHybridUnwrapper) UnwrapKey(wrapped string) ([]byte, error) {
+	enc, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return u.hd.Decrypt(enc, nil)
+}
+
+// Wraps document keys with a symmetric AEAD, such as a KMS key shared with an
+// internal service. The domain is bound to the ciphertext as associated data,
+// so an entry cannot be moved to another domain.
+type AEADRecipient struct {
+	domain string
+	aead   tink.AEAD
+}
+
+// Public function to create an AEADRecipient for the domain.
+func NewAEADRecipient(domain string, a tink.AEAD) *AEADRecipient {
+	return &AEADRecipient{domain: domain, aead: a}
+}
+
+func (r *AEADRecipient) Domain() string {
+	return r.domain
+}
+
+// AEAD recipients are not told apart by key ID.
+func (r *AEADRecipient) KeyID() string {
+	return ""
+}
+
+// Returns the base64 encoded AEAD ciphertext of the swgEncryptionKey.
+func (r *AEADRecipient) WrapKey(docKey []byte, accessRequirements []string) (string, error) {
+	payload, err := newSwgEncryptionKeyPayload(docKey, accessRequirements)
+	if err != nil {
+		return "", err
+	}
+	enc, err := r.aead.Encrypt(payload, []byte(r.domain))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(enc), nil
+}
+
+// Unwraps entries of an AEADRecipient for the same domain and AEAD.
+type AEADUnwrapper struct {
+	domain string
+	aead   tink.AEAD
+}
+
+// Public function to create an AEADUnwrapper for the domain.
+func NewAEADUnwrapper(domain string, a tink.AEAD) *AEADUnwrapper {
+	return &AEADUnwrapper{domain: domain, aead: a}
+}
+
+func (u *AEADUnwrapper) HasKey(keyID string) bool {
+	return true
+}
+
+func (u *AEADUnwrapper) UnwrapKey(wrapped string) ([]byte, error) {
+	enc, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return u.aead.Decrypt(enc, []byte(u.domain))
+}
+
+// Public function to create a TinkHybridRecipient for each domain.
+func NewTinkHybridRecipients(pubKeys map[string]tinkpb.Keyset) ([]Recipient, error) {
+	keysets := make(map[string][]tinkpb.Keyset)
+	for domain, ks := range pubKeys {
+		keysets[domain] = []tinkpb.Keyset{ks}
+	}
+	return NewTinkHybridRecipientsForKeysets(keysets)
+}
+
+// Public function to create a TinkHybridRecipient for each keyset of each
+// domain. A domain with several keysets gets the document key wrapped once
+// for each of them.
+func NewTinkHybridRecipientsForKeysets(pubKeys map[string][]tinkpb.Keyset) ([]Recipient, error) {
+	var domains []string
+	for domain := range pubKeys {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	var recipients []Recipient
+	for _, domain := range domains {
+		for _, ks := range pubKeys[domain] {
+			r, err := NewTinkHybridRecipient(domain, ks)
+			if err != nil {
+				return nil, err
+			}
+			recipients = append(recipients, r)
+		}
+	}
+	return recipients, nil
+}
+
+// Serializes the document key and access requirements wrapped for every
+// recipient.
+func newSwgEncryptionKeyPayload(docKey []byte, accessRequirements []string) ([]byte, error) {
+	swgKey := swgEncryptionKey{
+		AccessRequirements: accessRequirements,
+		Key:                base64.StdEncoding.EncodeToString(docKey),
+	}
+	return json.Marshal(swgKey)
+}
+
+// Wraps the document key for every recipient and returns the cryptokeys
+// entries by domain.
+func wrapDocumentKey(docKey []byte, accessRequirements []string, recipients []Recipient) (map[string][]WrappedKey, error) {
+	outMap := make(map[string][]WrappedKey)
+	for _, r := range recipients {
+		for _, wk := range outMap[r.Domain()] {
+			if wk.KeyID == r.KeyID() {
+				return nil, errors.New("Duplicate recipient key for domain " + r.Domain() + ": " + r.KeyID())
+			}
+		}
+		wrapped, err := r.WrapKey(docKey, accessRequirements)
+		if err != nil {
+			return nil, err
+		}
+		outMap[r.Domain()] = append(outMap[r.Domain()], WrappedKey{KeyID: r.KeyID(), Ciphertext: wrapped})
+	}
+	return outMap, nil
+}
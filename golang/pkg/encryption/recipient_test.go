@@ -0,0 +1,80 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bytes"
+	"github.com/google/tink/go/aead"
+	"github.com/google/tink/go/keyset"
+	"github.com/google/tink/go/tink"
+	"strings"
+	"testing"
+)
+
+func newTestAEAD(t *testing.T) tink.AEAD {
+	kh, err := keyset.NewHandle(aead.AES128GCMKeyTemplate())
+	if err != nil {
+		t.Fatalf("Failed to generate AEAD key: %v", err)
+	}
+	a, err := aead.New(kh)
+	if err != nil {
+		t.Fatalf("Failed to create AEAD: %v", err)
+	}
+	return a
+}
+
+func TestAEADRecipientRoundTrip(t *testing.T) {
+	a := newTestAEAD(t)
+	docKey := []byte("0123456789abcdef")
+	wrapped, err := NewAEADRecipient("internal", a).WrapKey(docKey, []string{"norcal.com:premium"})
+	if err != nil {
+		t.Fatalf("Failed to wrap document key: %v", err)
+	}
+	checker := &StaticEntitlementChecker{Grants: map[string][]string{"reader": []string{"norcal.com:premium"}}}
+	key, err := UnwrapDocumentKey(wrapped, NewAEADUnwrapper("internal", a), "reader", checker)
+	if err != nil {
+		t.Fatalf("Failed to unwrap document key: %v", err)
+	}
+	if !bytes.Equal(key, docKey) {
+		t.Errorf("Unwrapped key %x; want %x", key, docKey)
+	}
+	if _, err := UnwrapDocumentKey(wrapped, NewAEADUnwrapper("other", a), "reader", checker); err == nil {
+		t.Errorf("Expected failure unwrapping for another domain.")
+	}
+}
+
+func TestGenerateEncryptedDocumentForRecipients(t *testing.T) {
+	htmlStr, err := loadTestFileString("sample_encryption.html")
+	if err != nil {
+		t.Fatalf("HTML file load failed.")
+	}
+	_, pubKs := newTestKeyPair(t)
+	hr, err := NewTinkHybridRecipient("local", pubKs)
+	if err != nil {
+		t.Fatalf("Failed to create recipient: %v", err)
+	}
+	recipients := []Recipient{hr, NewAEADRecipient("internal", newTestAEAD(t))}
+	encDoc, err := GenerateEncryptedDocumentForRecipients(htmlStr, []string{"norcal.com:premium"}, recipients)
+	if err != nil {
+		t.Fatalf("Error occured generating encrypted document: %v", err)
+	}
+	if !strings.Contains(encDoc, `"local":`) || !strings.Contains(encDoc, `"internal":`) {
+		t.Errorf("Missing recipient keys in cryptokeys.")
+	}
+	recipients = append(recipients, hr)
+	if _, err := GenerateEncryptedDocumentForRecipients(htmlStr, []string{"norcal.com:premium"}, recipients); err == nil {
+		t.Errorf("Expected failure on duplicate recipient domain.")
+	}
+}
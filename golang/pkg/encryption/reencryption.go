@@ -0,0 +1,124 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"strings"
+)
+
+// Helper functions to handle sections that are already encrypted, e.g. when a
+// document is run through the encryption twice.
+
+// How GenerateEncryptedDocumentWithOptions handles content sections that
+// already contain only a ciphertext script.
+type EncryptedSectionMode int
+
+const (
+	// Reject documents with encrypted sections.
+	EncryptedSectionsFail EncryptedSectionMode = iota
+	// Leave encrypted sections as they are. As their document key can not be
+	// recovered, documents that also have plaintext sections are rejected.
+	EncryptedSectionsSkip
+	// Decrypt the sections with the document key unwrapped by
+	// EncryptOptions.Unwrapper and encrypt them again under a new key.
+	EncryptedSectionsReencrypt
+)
+
+// Returns true if the section only contains a ciphertext or reference
+// script, its blobs and teaser, ignoring whitespace.
+func isEncryptedSection(section *html.Node) bool {
+	var script *html.Node
+	for c := section.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode && strings.TrimSpace(c.Data) == "" {
+			continue
+		}
+		if script != nil && c.Type == html.ElementNode && c.DataAtom == atom.Script && hasAttr(c, blobScriptAttr) {
+			continue
+		}
+		if script == nil && c.Type == html.ElementNode && hasAttr(c, teaserAttr) {
+			continue
+		}
+		if script != nil || c.Type != html.ElementNode || c.DataAtom != atom.Script || findCiphertextScript(c) != c {
+			return false
+		}
+		script = c
+	}
+	return script != nil
+}
+
+// Handles the already encrypted sections according to the options and
+// returns the sections left to encrypt.
+func prepareSections(parsedHTML *html.Node, sections []*html.Node, opts EncryptOptions) ([]*html.Node, error) {
+	var plain, encrypted []*html.Node
+	for _, section := range sections {
+		if isEncryptedSection(section) {
+			encrypted = append(encrypted, section)
+		} else {
+			plain = append(plain, section)
+		}
+	}
+	if len(encrypted) == 0 {
+		return sections, nil
+	}
+	switch opts.EncryptedSections {
+	case EncryptedSectionsSkip:
+		if len(plain) != 0 {
+			return nil, errors.New("The document has both encrypted and plaintext sections. Re-encrypt it with the private key instead.")
+		}
+		return nil, nil
+	case EncryptedSectionsReencrypt:
+		if err := decryptExistingSections(parsedHTML, encrypted, opts); err != nil {
+			return nil, err
+		}
+		return sections, nil
+	}
+	return nil, errors.New("The document already has encrypted sections. Skip them, or re-encrypt them with the private key.")
+}
+
+// Decrypts the input sections in place with the document key of the
+// existing cryptokeys script, and removes that script.
+func decryptExistingSections(parsedHTML *html.Node, sections []*html.Node, opts EncryptOptions) error {
+	if opts.Unwrapper == nil {
+		return errors.New("Re-encryption requires an unwrapper for the existing document key.")
+	}
+	cryptoKeys := findScript(parsedHTML, "cryptokeys")
+	if cryptoKeys == nil {
+		return errors.New("No cryptokeys script found.")
+	}
+	ck, err := ParseCryptoKeys(scriptText(cryptoKeys))
+	if err != nil {
+		return err
+	}
+	docKey, err := unwrapExistingDocumentKey(scriptText(cryptoKeys), opts)
+	if err != nil {
+		return err
+	}
+	cipher, err := newDocumentAEAD(docKey)
+	if err != nil {
+		return err
+	}
+	source, _ := opts.External.(CiphertextSource)
+	for _, section := range sections {
+		if err := decryptSection(section, cipher, docKey, ck.Content, source); err != nil {
+			return err
+		}
+	}
+	// All entries of the script wrap the replaced document key.
+	cryptoKeys.Parent.RemoveChild(cryptoKeys)
+	return nil
+}
@@ -0,0 +1,94 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"strings"
+	"testing"
+)
+
+func TestIsEncryptedSection(t *testing.T) {
+	for in, want := range map[string]bool{
+		"<section>\n  <script ciphertext>abc</script>\n</section>":            true,
+		"<section><script ciphertext>abc</script><p>more</p></section>":       false,
+		"<section><script type=\"application/ld+json\">{}</script></section>": false,
+		"<section> </section>": false,
+		"<section><script ciphertext>abc</script><script ciphertext-blob=\"1\">def</script></section>": true,
+		"<section><script ciphertext-blob=\"1\">def</script></section>":                                false,
+	} {
+		body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+		nodes, err := html.ParseFragment(strings.NewReader(in), body)
+		if err != nil || len(nodes) != 1 {
+			t.Fatalf("Failed to parse %q: %v", in, err)
+		}
+		if got := isEncryptedSection(nodes[0]); got != want {
+			t.Errorf("isEncryptedSection(%q) = %v; want %v", in, got, want)
+		}
+	}
+}
+
+func TestEncryptDocumentTwice(t *testing.T) {
+	htmlStr, err := loadTestFileString("sample_encryption.html")
+	if err != nil {
+		t.Fatalf("HTML file load failed.")
+	}
+	recipients, u := newTestRecipient(t)
+	ar := []string{"norcal.com:premium"}
+	encDoc, err := GenerateEncryptedDocumentForRecipients(htmlStr, ar, recipients)
+	if err != nil {
+		t.Fatalf("Failed to encrypt document: %v", err)
+	}
+	if _, err := GenerateEncryptedDocumentForRecipients(encDoc, ar, recipients); err == nil {
+		t.Errorf("Expected failure on already encrypted document.")
+	}
+	skipped, err := GenerateEncryptedDocumentWithOptions(encDoc, ar, recipients, EncryptOptions{EncryptedSections: EncryptedSectionsSkip})
+	if err != nil {
+		t.Fatalf("Failed to skip encrypted sections: %v", err)
+	}
+	if skipped != encDoc {
+		t.Errorf("Skipping changed the document.")
+	}
+	if _, err := GenerateEncryptedDocumentWithOptions(encDoc, ar, recipients, EncryptOptions{EncryptedSections: EncryptedSectionsReencrypt}); err == nil {
+		t.Errorf("Expected failure on re-encryption without unwrapper.")
+	}
+	opts := EncryptOptions{EncryptedSections: EncryptedSectionsReencrypt, Unwrapper: u, UnwrapDomain: "local"}
+	reencDoc, err := GenerateEncryptedDocumentWithOptions(encDoc, ar, recipients, opts)
+	if err != nil {
+		t.Fatalf("Failed to re-encrypt document: %v", err)
+	}
+	if n := strings.Count(reencDoc, "cryptokeys"); n != 1 {
+		t.Errorf("Got %d cryptokeys scripts; want 1", n)
+	}
+	decDoc, err := DecryptDocument(reencDoc, "local", u, "reader", testChecker)
+	if err != nil {
+		t.Fatalf("Failed to decrypt re-encrypted document: %v", err)
+	}
+	if !strings.Contains(decDoc, "This is some seriously premium content!") || strings.Contains(decDoc, "ciphertext") {
+		t.Errorf("Re-encrypted document was not decrypted once: %s", decDoc)
+	}
+}
+
+func TestEncryptDocumentSkipMixedSections(t *testing.T) {
+	htmlStr := `<!doctype html><html ⚡><head></head><body>
+	<section subscriptions-section="content" encrypted><script ciphertext>abc</script></section>
+	<section subscriptions-section="content" encrypted><p>plain</p></section>
+	</body></html>`
+	recipients, _ := newTestRecipient(t)
+	if _, err := GenerateEncryptedDocumentWithOptions(htmlStr, nil, recipients, EncryptOptions{EncryptedSections: EncryptedSectionsSkip}); err == nil {
+		t.Errorf("Expected failure on mixed sections.")
+	}
+}
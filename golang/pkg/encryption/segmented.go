@@ -0,0 +1,226 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"math"
+)
+
+// Helper functions for the segmented section format, a streaming AEAD in the
+// style of Tink's AES-GCM-HKDF streaming AEAD. The ciphertext starts with a
+// header of a random salt and nonce prefix. The segment key is derived from
+// the document key and the salt with HKDF-SHA256, and the plaintext is split
+// into segments of the segment size, each sealed with AES-GCM. The segment
+// nonce is the nonce prefix, the big-endian segment number and a byte set on
+// the last segment only, so that reordered, dropped or truncated segments
+// fail to decrypt.
+
+const (
+	segmentSaltSize        int = 16
+	segmentNoncePrefixSize int = 7
+	segmentTagSize         int = 16
+	// Largest plaintext segment size accepted, bounding the memory used.
+	MaxSegmentSize int = 1 << 20
+)
+
+var segmentKeyInfo = []byte("swg segmented section")
+
+// Public function to create a writer encrypting the content written to it in
+// the segmented format to w. At most one segment is buffered. Close must be
+// called to write the last segment.
+func NewSegmentedEncrypter(w io.Writer, docKey []byte, segmentSize int) (io.WriteCloser, error) {
+	if segmentSize <= 0 || segmentSize > MaxSegmentSize {
+		return nil, errors.New("Invalid segment size.")
+	}
+	header := make([]byte, segmentSaltSize+segmentNoncePrefixSize)
+	if _, err := rand.Read(header); err != nil {
+		return nil, err
+	}
+	gcm, err := newSegmentAEAD(docKey, header[:segmentSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &segmentedEncrypter{
+		w:           w,
+		gcm:         gcm,
+		noncePrefix: header[segmentSaltSize:],
+		buf:         make([]byte, 0, segmentSize),
+	}, nil
+}
+
+type segmentedEncrypter struct {
+	w           io.Writer
+	gcm         cipher.AEAD
+	noncePrefix []byte
+	buf         []byte
+	segment     uint32
+	closed      bool
+}
+
+func (e *segmentedEncrypter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("Write to closed segmented encrypter.")
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full segment is only sealed once more content shows that it is
+		// not the last one.
+		if len(e.buf) == cap(e.buf) {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Seals the buffered content as the last segment.
+func (e *segmentedEncrypter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+func (e *segmentedEncrypter) seal(last bool) error {
+	if e.segment == math.MaxUint32 {
+		return errors.New("Too many segments.")
+	}
+	ct := e.gcm.Seal(nil, segmentNonce(e.noncePrefix, e.segment, last), e.buf, nil)
+	e.segment++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(ct)
+	return err
+}
+
+// Public function to create a reader decrypting content in the segmented
+// format read from r. Content is only returned once its segment is
+// authenticated, and a read fails if the segments were reordered or the
+// content was truncated.
+func NewSegmentedDecrypter(r io.Reader, docKey []byte, segmentSize int) (io.Reader, error) {
+	if segmentSize <= 0 || segmentSize > MaxSegmentSize {
+		return nil, errors.New("Invalid segment size.")
+	}
+	header := make([]byte, segmentSaltSize+segmentNoncePrefixSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errors.New("Segmented ciphertext has no header.")
+	}
+	gcm, err := newSegmentAEAD(docKey, header[:segmentSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	return &segmentedDecrypter{
+		r:           bufio.NewReader(r),
+		gcm:         gcm,
+		noncePrefix: header[segmentSaltSize:],
+		ct:          make([]byte, segmentSize+segmentTagSize),
+	}, nil
+}
+
+type segmentedDecrypter struct {
+	r           *bufio.Reader
+	gcm         cipher.AEAD
+	noncePrefix []byte
+	ct          []byte
+	out         []byte
+	segment     uint32
+	done        bool
+}
+
+func (d *segmentedDecrypter) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// Reads and decrypts the next segment. A segment is the last one if the
+// ciphertext ends with it.
+func (d *segmentedDecrypter) open() error {
+	n, err := io.ReadFull(d.r, d.ct)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return errors.New("Segmented ciphertext is truncated.")
+		}
+		return err
+	}
+	last := err == io.ErrUnexpectedEOF
+	if !last {
+		if _, err := d.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if !last && d.segment == math.MaxUint32 {
+		return errors.New("Too many segments.")
+	}
+	pt, err := d.gcm.Open(nil, segmentNonce(d.noncePrefix, d.segment, last), d.ct[:n], nil)
+	if err != nil {
+		return errors.New("Failed to decrypt segment.")
+	}
+	d.segment++
+	d.out = pt
+	d.done = last
+	return nil
+}
+
+// Derives the AES-GCM cipher of the segments from the document key and salt.
+func newSegmentAEAD(docKey []byte, salt []byte) (cipher.AEAD, error) {
+	key := make([]byte, len(docKey))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, docKey, salt, segmentKeyInfo), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns the nonce of a segment.
+func segmentNonce(prefix []byte, segment uint32, last bool) []byte {
+	nonce := make([]byte, segmentNoncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[segmentNoncePrefixSize:], segment)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
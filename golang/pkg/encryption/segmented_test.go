@@ -0,0 +1,110 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+var testSegmentKey = []byte("0123456789abcdef")
+
+// Encrypts the input content in the segmented format, written in chunks of
+// 7 bytes.
+func segmentedTestCiphertext(t *testing.T, content []byte, segmentSize int) []byte {
+	var buf bytes.Buffer
+	w, err := NewSegmentedEncrypter(&buf, testSegmentKey, segmentSize)
+	if err != nil {
+		t.Fatalf("Failed to create encrypter: %v", err)
+	}
+	for p := content; len(p) > 0; {
+		n := 7
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatalf("Failed to encrypt: %v", err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decryptSegmentedTest(ct []byte, segmentSize int) ([]byte, error) {
+	r, err := NewSegmentedDecrypter(iotest.HalfReader(bytes.NewReader(ct)), testSegmentKey, segmentSize)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestSegmentedRoundTrip(t *testing.T) {
+	const segmentSize = 64
+	for _, n := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3 * segmentSize} {
+		content := bytes.Repeat([]byte("x"), n)
+		ct := segmentedTestCiphertext(t, content, segmentSize)
+		segments := (n + segmentSize - 1) / segmentSize
+		if segments == 0 {
+			segments = 1
+		}
+		if want := segmentSaltSize + segmentNoncePrefixSize + n + segments*segmentTagSize; len(ct) != want {
+			t.Errorf("Ciphertext of %d bytes has length %d; want %d", n, len(ct), want)
+		}
+		pt, err := decryptSegmentedTest(ct, segmentSize)
+		if err != nil {
+			t.Fatalf("Failed to decrypt %d bytes: %v", n, err)
+		}
+		if !bytes.Equal(pt, content) {
+			t.Errorf("Round trip of %d bytes changed the content.", n)
+		}
+	}
+}
+
+func TestSegmentedTampering(t *testing.T) {
+	const segmentSize = 16
+	header := segmentSaltSize + segmentNoncePrefixSize
+	ctSegment := segmentSize + segmentTagSize
+	ct := segmentedTestCiphertext(t, bytes.Repeat([]byte("abcd"), 12), segmentSize)
+	swapped := append([]byte{}, ct[:header]...)
+	swapped = append(swapped, ct[header+ctSegment:header+2*ctSegment]...)
+	swapped = append(swapped, ct[header:header+ctSegment]...)
+	swapped = append(swapped, ct[header+2*ctSegment:]...)
+	flipped := append([]byte{}, ct...)
+	flipped[header+1] ^= 1
+	for name, bad := range map[string][]byte{
+		"reordered":        swapped,
+		"truncated":        ct[:header+2*ctSegment],
+		"cut mid segment":  ct[:len(ct)-1],
+		"modified":         flipped,
+		"header only":      ct[:header],
+		"missing header":   ct[:header-1],
+		"appended segment": append(append([]byte{}, ct...), ct[header:header+ctSegment]...),
+	} {
+		if _, err := decryptSegmentedTest(bad, segmentSize); err == nil {
+			t.Errorf("Expected failure on %s ciphertext.", name)
+		}
+	}
+	if _, err := decryptSegmentedTest(ct, segmentSize*2); err == nil {
+		t.Errorf("Expected failure on wrong segment size.")
+	}
+	if _, err := NewSegmentedEncrypter(ioutil.Discard, testSegmentKey, MaxSegmentSize+1); err == nil {
+		t.Errorf("Expected failure on oversized segments.")
+	}
+}
@@ -0,0 +1,100 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"github.com/google/tink/go/keyset"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+)
+
+// Helper functions to check that a published public key matches a private key.
+
+const selfTestAccessRequirement string = "selftest:selftest"
+
+// Result of a key pair self-test.
+type KeyPairReport struct {
+	Passed bool
+	// Reason the test failed, empty if it passed.
+	Reason string
+	// Primary key ID of the public keyset, which encrypts document keys.
+	PublicPrimaryKeyID uint32
+	// IDs of all keys in the public and private keysets.
+	PublicKeyIDs  []uint32
+	PrivateKeyIDs []uint32
+}
+
+// Public function to check that the private keyset decrypts document keys
+// encrypted with the public keyset. A random document key is encrypted with
+// the public keyset the same way as in GenerateEncryptedDocument and then
+// read back with the private keyset from cryptokeys scripts of every
+// version. A mismatched key pair is reported as a
+// failed test; errors are only returned if the test could not be run.
+func VerifyKeyPair(pubKey tinkpb.Keyset, privKh *keyset.Handle) (*KeyPairReport, error) {
+	r := &KeyPairReport{PublicPrimaryKeyID: pubKey.PrimaryKeyId}
+	for _, k := range pubKey.Key {
+		r.PublicKeyIDs = append(r.PublicKeyIDs, k.KeyId)
+	}
+	privPub, err := privKh.Public()
+	if err != nil {
+		return nil, err
+	}
+	mem := &keyset.MemReaderWriter{}
+	if err := privPub.WriteWithNoSecrets(mem); err != nil {
+		return nil, err
+	}
+	for _, k := range mem.Keyset.Key {
+		r.PrivateKeyIDs = append(r.PrivateKeyIDs, k.KeyId)
+	}
+	docKey := make([]byte, aesGCMKeySize)
+	if _, err := rand.Read(docKey); err != nil {
+		return nil, err
+	}
+	recipients, err := NewTinkHybridRecipients(map[string]tinkpb.Keyset{"local": pubKey})
+	if err != nil {
+		r.Reason = fmt.Sprintf("Could not encrypt with the public key: %v", err)
+		return r, nil
+	}
+	wrappedKeys, err := wrapDocumentKey(docKey, []string{selfTestAccessRequirement}, recipients)
+	if err != nil {
+		r.Reason = fmt.Sprintf("Could not encrypt with the public key: %v", err)
+		return r, nil
+	}
+	u, err := NewTinkHybridUnwrapper(privKh)
+	if err != nil {
+		return nil, err
+	}
+	checker := &StaticEntitlementChecker{Grants: map[string][]string{selfTestAccessRequirement: []string{selfTestAccessRequirement}}}
+	// Read the document key back from every cryptokeys version.
+	for _, version := range []int{CryptoKeysV1, CryptoKeysV2} {
+		cryptoKeys, err := marshalCryptoKeys(wrappedKeys, version, ContentParams{})
+		if err != nil {
+			return nil, err
+		}
+		key, err := UnwrapDomainDocumentKey(string(cryptoKeys), "local", u, selfTestAccessRequirement, checker)
+		if err != nil {
+			r.Reason = fmt.Sprintf("The private keyset does not decrypt documents encrypted with public key %d: %v", r.PublicPrimaryKeyID, err)
+			return r, nil
+		}
+		if !bytes.Equal(key, docKey) {
+			r.Reason = "The decrypted document key does not match the encrypted one."
+			return r, nil
+		}
+	}
+	r.Passed = true
+	return r, nil
+}
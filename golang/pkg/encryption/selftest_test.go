@@ -0,0 +1,58 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"testing"
+)
+
+func TestVerifyKeyPairMatching(t *testing.T) {
+	privKh, pubKs := newTestKeyPair(t)
+	r, err := VerifyKeyPair(pubKs, privKh)
+	if err != nil {
+		t.Fatalf("Self-test failed to run: %v", err)
+	}
+	if !r.Passed {
+		t.Errorf("Self-test failed for a matching key pair: %s", r.Reason)
+	}
+	if r.PublicPrimaryKeyID != pubKs.PrimaryKeyId || len(r.PrivateKeyIDs) != 1 || r.PrivateKeyIDs[0] != pubKs.PrimaryKeyId {
+		t.Errorf("Unexpected key IDs: %+v", r)
+	}
+}
+
+func TestVerifyKeyPairMismatched(t *testing.T) {
+	privKh, _ := newTestKeyPair(t)
+	_, otherPubKs := newTestKeyPair(t)
+	r, err := VerifyKeyPair(otherPubKs, privKh)
+	if err != nil {
+		t.Fatalf("Self-test failed to run: %v", err)
+	}
+	if r.Passed {
+		t.Errorf("Self-test passed for a mismatched key pair.")
+	}
+	if r.Reason == "" {
+		t.Errorf("Missing failure reason.")
+	}
+}
+
+func TestLoadTinkPublicKeyFile(t *testing.T) {
+	ks, err := LoadTinkPublicKey("testdata/google_public_key.json")
+	if err != nil {
+		t.Fatalf("Failed to load public key file: %v", err)
+	}
+	if ks.PrimaryKeyId != googPrimaryKeyId {
+		t.Errorf("Invalid primary key ID %d. Want: %d", ks.PrimaryKeyId, googPrimaryKeyId)
+	}
+}
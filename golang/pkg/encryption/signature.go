@@ -0,0 +1,192 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/google/tink/go/keyset"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"github.com/google/tink/go/signature"
+	"github.com/google/tink/go/tink"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"hash"
+	"strings"
+)
+
+// Helper functions to sign encrypted documents, so that clients can check
+// that the cryptokeys and ciphertext come from the publisher. The signature
+// is a Tink signature of a digest of the document, in a script in the head:
+//
+// 	<script type="application/json" swg-signature>{"v":1,"sig":"<base64>"}</script>
+//
+// The digest is SHA-256 of the length-prefixed string "swg-signature-v1",
+// followed by the length-prefixed kind and data of each of these scripts, in
+// document order:
+// 	- "cryptokeys": the exact text of the cryptokeys script, as UTF-8.
+// 	- "ciphertext": the binary section ciphertext.
+// 	- "ciphertext-src": the URL and integrity of an externalized section,
+// 	  separated by a newline.
+// 	- "ciphertext-blob": the length-prefixed blob ID and the binary blob.
+// 	- "ciphertext-blob-src": the length-prefixed blob ID, followed by the URL
+// 	  and integrity of an externalized blob separated by a newline.
+// Length prefixes are 4 bytes big-endian.
+
+// Attribute of the signature script.
+const signatureAttr string = "swg-signature"
+
+const signatureVersion int = 1
+const signatureContext string = "swg-signature-v1"
+
+// Contents of the signature script.
+type documentSignature struct {
+	V   int    `json:"v"`
+	Sig string `json:"sig"`
+}
+
+// Signs the document and adds the signature script to the head, replacing
+// an existing one.
+func signDocument(parsedHTML *html.Node, signer tink.Signer) error {
+	digest, err := documentDigest(parsedHTML)
+	if err != nil {
+		return err
+	}
+	sig, err := signer.Sign(digest)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(documentSignature{V: signatureVersion, Sig: base64.StdEncoding.EncodeToString(sig)})
+	if err != nil {
+		return err
+	}
+	if existing := findScript(parsedHTML, signatureAttr); existing != nil {
+		existing.Parent.RemoveChild(existing)
+	}
+	head := findElement(parsedHTML, atom.Head)
+	if head == nil {
+		return errors.New("Could not add the signature to head.")
+	}
+	script := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "script",
+		DataAtom: atom.Script,
+		Attr: []html.Attribute{
+			html.Attribute{Key: "type", Val: "application/json"},
+			html.Attribute{Key: signatureAttr, Val: ""},
+		},
+	}
+	script.AppendChild(&html.Node{Type: html.TextNode, Data: string(b)})
+	head.AppendChild(script)
+	return nil
+}
+
+// Public function to verify the publisher signature of an encrypted
+// document.
+func VerifyDocumentSignature(htmlStr string, verifier tink.Verifier) error {
+	parsedHTML, err := html.Parse(strings.NewReader(htmlStr))
+	if err != nil {
+		return err
+	}
+	script := findScript(parsedHTML, signatureAttr)
+	if script == nil {
+		return errors.New("No signature script found.")
+	}
+	var ds documentSignature
+	if err := json.Unmarshal([]byte(scriptText(script)), &ds); err != nil {
+		return err
+	}
+	if ds.V != signatureVersion {
+		return errors.New("Unsupported signature version.")
+	}
+	sig, err := base64.StdEncoding.DecodeString(ds.Sig)
+	if err != nil {
+		return err
+	}
+	digest, err := documentDigest(parsedHTML)
+	if err != nil {
+		return err
+	}
+	if err := verifier.Verify(sig, digest); err != nil {
+		return errors.New("Invalid document signature.")
+	}
+	return nil
+}
+
+// Public function to create the verifier of document signatures from the
+// publisher's public signing keyset.
+func NewSignatureVerifier(pubKs tinkpb.Keyset) (tink.Verifier, error) {
+	kh, err := keyset.NewHandleWithNoSecrets(&pubKs)
+	if err != nil {
+		return nil, err
+	}
+	return signature.NewVerifier(kh)
+}
+
+// Computes the signed digest of the document.
+func documentDigest(parsedHTML *html.Node) ([]byte, error) {
+	h := sha256.New()
+	h.Write(lengthPrefixed([]byte(signatureContext)))
+	if err := digestScripts(h, parsedHTML); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Adds the signed scripts at or below n to the digest.
+func digestScripts(h hash.Hash, n *html.Node) error {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Script {
+		kind, data, err := signedScriptData(n)
+		if err != nil {
+			return err
+		}
+		if kind != "" {
+			h.Write(lengthPrefixed([]byte(kind)))
+			h.Write(lengthPrefixed(data))
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if err := digestScripts(h, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the kind and signed data of a script, or no kind if the script is
+// not signed.
+func signedScriptData(n *html.Node) (string, []byte, error) {
+	switch {
+	case hasAttr(n, "cryptokeys"):
+		// The text is signed as is rather than in a canonical JSON form, so
+		// that any verifier can reproduce the data.
+		return "cryptokeys", []byte(scriptText(n)), nil
+	case hasAttr(n, "ciphertext"):
+		b, err := base64.StdEncoding.DecodeString(scriptText(n))
+		return "ciphertext", b, err
+	case hasAttr(n, blobScriptAttr):
+		id := lengthPrefixed([]byte(getAttr(n, blobScriptAttr)))
+		if hasAttr(n, ciphertextSrcAttr) {
+			return blobScriptAttr + "-src", append(id, getAttr(n, ciphertextSrcAttr)+"\n"+getAttr(n, "integrity")...), nil
+		}
+		b, err := base64.StdEncoding.DecodeString(scriptText(n))
+		return blobScriptAttr, append(id, b...), err
+	case hasAttr(n, ciphertextSrcAttr):
+		return ciphertextSrcAttr, []byte(getAttr(n, ciphertextSrcAttr) + "\n" + getAttr(n, "integrity")), nil
+	}
+	return "", nil, nil
+}
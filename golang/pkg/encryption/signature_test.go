@@ -0,0 +1,161 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	"github.com/google/tink/go/signature"
+	"github.com/google/tink/go/tink"
+	"golang.org/x/net/html"
+	"strings"
+	"testing"
+)
+
+func newTestSigner(t *testing.T) (tink.Signer, tink.Verifier) {
+	privKh, err := keyset.NewHandle(signature.ECDSAP256KeyTemplate())
+	if err != nil {
+		t.Fatalf("Failed to generate signing key: %v", err)
+	}
+	pubKh, err := privKh.Public()
+	if err != nil {
+		t.Fatalf("Failed to get public signing key: %v", err)
+	}
+	exported := &keyset.MemReaderWriter{}
+	if err := insecurecleartextkeyset.Write(pubKh, exported); err != nil {
+		t.Fatalf("Failed to export public signing key: %v", err)
+	}
+	signer, err := signature.NewSigner(privKh)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	verifier, err := NewSignatureVerifier(*exported.Keyset)
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	return signer, verifier
+}
+
+// Replaces the text of the first script with the attribute using edit.
+func editScript(t *testing.T, htmlStr string, attr string, edit func(string) string) string {
+	parsedHTML, err := html.Parse(strings.NewReader(htmlStr))
+	if err != nil {
+		t.Fatalf("Failed to parse document: %v", err)
+	}
+	script := findScript(parsedHTML, attr)
+	if script == nil {
+		t.Fatalf("Missing %s script.", attr)
+	}
+	text := edit(scriptText(script))
+	for c := script.FirstChild; c != nil; c = script.FirstChild {
+		script.RemoveChild(c)
+	}
+	script.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+	return renderNode(parsedHTML)
+}
+
+func TestSignedDocument(t *testing.T) {
+	htmlStr, err := loadTestFileString("sample_encryption.html")
+	if err != nil {
+		t.Fatalf("HTML file load failed.")
+	}
+	recipients, _ := newTestRecipient(t)
+	signer, verifier := newTestSigner(t)
+	encDoc, err := GenerateEncryptedDocumentWithOptions(htmlStr, []string{"norcal.com:premium"}, recipients, EncryptOptions{Signer: signer})
+	if err != nil {
+		t.Fatalf("Failed to encrypt document: %v", err)
+	}
+	if strings.Count(encDoc, signatureAttr) != 1 {
+		t.Fatalf("Want one signature script: %s", encDoc)
+	}
+	if err := VerifyDocumentSignature(encDoc, verifier); err != nil {
+		t.Errorf("Failed to verify signature: %v", err)
+	}
+	_, otherVerifier := newTestSigner(t)
+	if err := VerifyDocumentSignature(encDoc, otherVerifier); err == nil {
+		t.Errorf("Expected failure with another publisher key.")
+	}
+	tampered := map[string]string{
+		"cryptokeys": editScript(t, encDoc, "cryptokeys", func(s string) string {
+			return strings.Replace(s, "local", "other", 1)
+		}),
+		"reformatted cryptokeys": editScript(t, encDoc, "cryptokeys", func(s string) string {
+			var v interface{}
+			if err := json.Unmarshal([]byte(s), &v); err != nil {
+				t.Fatalf("Failed to parse cryptokeys: %v", err)
+			}
+			b, _ := json.MarshalIndent(v, "", "  ")
+			return string(b)
+		}),
+		"ciphertext": editScript(t, encDoc, "ciphertext", func(s string) string {
+			b, _ := base64.StdEncoding.DecodeString(s)
+			b[len(b)-1] ^= 1
+			return base64.StdEncoding.EncodeToString(b)
+		}),
+		"signature": editScript(t, encDoc, signatureAttr, func(s string) string {
+			return strings.Replace(s, `"v":1`, `"v":2`, 1)
+		}),
+		"unsigned": strings.Replace(encDoc, signatureAttr, "other-signature", 1),
+	}
+	for name, doc := range tampered {
+		if err := VerifyDocumentSignature(doc, verifier); err == nil {
+			t.Errorf("Expected failure on %s.", name)
+		}
+	}
+	resigned, err := GenerateEncryptedDocumentWithOptions(encDoc, []string{"norcal.com:premium"}, recipients, EncryptOptions{
+		EncryptedSections: EncryptedSectionsSkip,
+		MergeCryptoKeys:   true,
+		Signer:            signer,
+	})
+	if err != nil {
+		t.Fatalf("Failed to re-sign document: %v", err)
+	}
+	if strings.Count(resigned, signatureAttr) > 1 {
+		t.Errorf("Want at most one signature script: %s", resigned)
+	}
+}
+
+func TestSignedDocumentExternalAndBlobs(t *testing.T) {
+	doc := `<html><head></head><body>` +
+		`<script type="application/octet-stream" ciphertext-src="/ct/a.bin" integrity="sha384-abc"></script>` +
+		`<script type="application/octet-stream" ciphertext-blob="b0">AAEC</script>` +
+		`<script type="application/octet-stream" ciphertext-src="/ct/c.bin" integrity="sha384-def" ciphertext-blob="b2"></script></body></html>`
+	parsedHTML, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("Failed to parse document: %v", err)
+	}
+	signer, verifier := newTestSigner(t)
+	if err := signDocument(parsedHTML, signer); err != nil {
+		t.Fatalf("Failed to sign document: %v", err)
+	}
+	signed := renderNode(parsedHTML)
+	if err := VerifyDocumentSignature(signed, verifier); err != nil {
+		t.Errorf("Failed to verify signature: %v", err)
+	}
+	for _, tc := range [][2]string{
+		{`ciphertext-src="/ct/a.bin"`, `ciphertext-src="/ct/b.bin"`},
+		{`integrity="sha384-abc"`, `integrity="sha384-abd"`},
+		{`ciphertext-blob="b0"`, `ciphertext-blob="b1"`},
+		{`>AAEC<`, `>AAED<`},
+		{`ciphertext-src="/ct/c.bin"`, `ciphertext-src="/ct/d.bin"`},
+		{`ciphertext-blob="b2"`, `ciphertext-blob="b3"`},
+	} {
+		if err := VerifyDocumentSignature(strings.Replace(signed, tc[0], tc[1], 1), verifier); err == nil {
+			t.Errorf("Expected failure on %s.", tc[1])
+		}
+	}
+}
@@ -0,0 +1,166 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bytes"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	htmltemplate "html/template"
+	"strings"
+)
+
+// Helper functions to keep a teaser in encrypted sections for crawlers and
+// readers without JavaScript. The teaser goes in a marked element before the
+// ciphertext script, which clients remove once the section is decrypted:
+//
+// 	<div swg-teaser><p>The first words of the section…</p></div>
+
+// Attribute marking the teaser element of an encrypted section.
+const teaserAttr string = "swg-teaser"
+
+// Options of the teaser kept in encrypted sections. The zero value keeps no
+// teaser.
+type TeaserOptions struct {
+	// Number of words of the section text to keep.
+	Words int
+	// Keep the text of the first paragraph of the section instead.
+	FirstParagraph bool
+	// HTML template of the teaser, executed with the teaser text as
+	// .Teaser. The text is wrapped in a paragraph if empty.
+	Template string
+}
+
+// Template data of TeaserOptions.Template.
+type teaserData struct {
+	Teaser string
+}
+
+// Builds the teasers of the sections of a document. The template is parsed
+// once and cloned for each section.
+type teaserBuilder struct {
+	opts     TeaserOptions
+	template *htmltemplate.Template
+}
+
+// Creates a teaserBuilder, parsing the template of the options if any.
+func newTeaserBuilder(opts TeaserOptions) (*teaserBuilder, error) {
+	b := &teaserBuilder{opts: opts}
+	if opts.Template != "" {
+		t, err := htmltemplate.New("teaser").Parse(opts.Template)
+		if err != nil {
+			return nil, err
+		}
+		b.template = t
+	}
+	return b, nil
+}
+
+// Returns the teaser element of the section's plaintext content, or nil if
+// the options keep no teaser.
+func (tb *teaserBuilder) build(section *html.Node) (*html.Node, error) {
+	opts := tb.opts
+	var text string
+	if opts.FirstParagraph {
+		if p := findElement(section, atom.P); p != nil {
+			text = strings.Join(strings.Fields(textContent(p)), " ")
+		}
+	} else if opts.Words > 0 {
+		words := strings.Fields(textContent(section))
+		if len(words) > opts.Words {
+			words = append(words[:opts.Words:opts.Words], "…")
+		}
+		text = strings.Join(words, " ")
+	}
+	var b bytes.Buffer
+	if tb.template != nil {
+		t, err := tb.template.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if err := t.Execute(&b, teaserData{Teaser: text}); err != nil {
+			return nil, err
+		}
+	} else if text != "" {
+		b.WriteString("<p>" + html.EscapeString(text) + "</p>")
+	} else {
+		return nil, nil
+	}
+	teaser := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+		Attr:     []html.Attribute{html.Attribute{Key: teaserAttr, Val: ""}},
+	}
+	nodes, err := html.ParseFragment(&b, teaser)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		teaser.AppendChild(n)
+	}
+	return teaser, nil
+}
+
+// Removes the teaser elements of a section.
+func removeTeasers(section *html.Node) {
+	for c := section.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.ElementNode && hasAttr(c, teaserAttr) {
+			section.RemoveChild(c)
+		}
+		c = next
+	}
+}
+
+// Returns the text below n, leaving out scripts, styles and templates.
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	if n.Type == html.ElementNode && (n.DataAtom == atom.Script || n.DataAtom == atom.Style || n.DataAtom == atom.Template) {
+		return ""
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+		// Text of adjacent blocks are separate words.
+		if c.Type == html.ElementNode && teaserBlockElements[c.DataAtom] {
+			b.WriteString(" ")
+		}
+	}
+	return b.String()
+}
+
+// Elements whose text is not joined with the text around them.
+var teaserBlockElements = map[atom.Atom]bool{
+	atom.Article: true, atom.Blockquote: true, atom.Br: true, atom.Div: true,
+	atom.Figcaption: true, atom.H1: true, atom.H2: true, atom.H3: true,
+	atom.H4: true, atom.H5: true, atom.H6: true, atom.Li: true, atom.P: true,
+	atom.Section: true, atom.Td: true, atom.Th: true,
+}
+
+// Returns the first element of the input type below n.
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == a {
+			return c
+		}
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
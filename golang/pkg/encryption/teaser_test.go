@@ -0,0 +1,112 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"strings"
+	"testing"
+)
+
+const teaserTestSection = `<h3>Exclusive</h3><p>The <b>mayor</b> resigned on Monday.</p><script>var x;</script><p>More details follow.</p>`
+
+func TestBuildTeaser(t *testing.T) {
+	for _, tc := range []struct {
+		opts TeaserOptions
+		want string
+	}{
+		{TeaserOptions{}, ""},
+		{TeaserOptions{Words: 3}, `<div swg-teaser=""><p>Exclusive The mayor …</p></div>`},
+		{TeaserOptions{Words: 100}, `<div swg-teaser=""><p>Exclusive The mayor resigned on Monday. More details follow.</p></div>`},
+		{TeaserOptions{FirstParagraph: true}, `<div swg-teaser=""><p>The mayor resigned on Monday.</p></div>`},
+		{TeaserOptions{Words: 2, Template: `<p class="teaser">{{.Teaser}}</p><a href="/subscribe">Subscribe</a>`}, `<div swg-teaser=""><p class="teaser">Exclusive The …</p><a href="/subscribe">Subscribe</a></div>`},
+		{TeaserOptions{Template: `<p>Subscribe to read.</p>`}, `<div swg-teaser=""><p>Subscribe to read.</p></div>`},
+	} {
+		section := &html.Node{Type: html.ElementNode, Data: "section", DataAtom: atom.Section}
+		nodes, err := html.ParseFragment(strings.NewReader(teaserTestSection), section)
+		if err != nil {
+			t.Fatalf("Failed to parse section: %v", err)
+		}
+		for _, n := range nodes {
+			section.AppendChild(n)
+		}
+		tb, err := newTeaserBuilder(tc.opts)
+		if err != nil {
+			t.Fatalf("Failed to parse teaser template of %+v: %v", tc.opts, err)
+		}
+		teaser, err := tb.build(section)
+		if err != nil {
+			t.Fatalf("Failed to build teaser with %+v: %v", tc.opts, err)
+		}
+		got := ""
+		if teaser != nil {
+			got = renderNode(teaser)
+		}
+		if got != tc.want {
+			t.Errorf("Teaser with %+v is %s; want %s", tc.opts, got, tc.want)
+		}
+	}
+}
+
+func TestTeaserBuilderReusesTemplate(t *testing.T) {
+	if _, err := newTeaserBuilder(TeaserOptions{Template: `{{.Teaser`}); err == nil {
+		t.Errorf("Expected failure on malformed template.")
+	}
+	tb, err := newTeaserBuilder(TeaserOptions{Words: 1, Template: `<p>{{.Teaser}}</p>`})
+	if err != nil {
+		t.Fatalf("Failed to parse teaser template: %v", err)
+	}
+	for _, text := range []string{"First section", "Second section"} {
+		section := &html.Node{Type: html.ElementNode, Data: "section", DataAtom: atom.Section}
+		section.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+		teaser, err := tb.build(section)
+		if err != nil {
+			t.Fatalf("Failed to build teaser: %v", err)
+		}
+		if got, want := renderNode(teaser), `<div swg-teaser=""><p>`+strings.Fields(text)[0]+` …</p></div>`; got != want {
+			t.Errorf("Teaser is %s; want %s", got, want)
+		}
+	}
+}
+
+func TestEncryptDocumentTeaser(t *testing.T) {
+	htmlStr, err := loadTestFileString("sample_encryption.html")
+	if err != nil {
+		t.Fatalf("HTML file load failed.")
+	}
+	recipients, u := newTestRecipient(t)
+	ar := []string{"norcal.com:premium"}
+	opts := EncryptOptions{Teaser: TeaserOptions{Words: 4, Template: `<p>{{.Teaser}} <a href="/subscribe">Subscribe</a></p>`}}
+	encDoc, err := GenerateEncryptedDocumentWithOptions(htmlStr, ar, recipients, opts)
+	if err != nil {
+		t.Fatalf("Failed to encrypt document: %v", err)
+	}
+	if !strings.Contains(encDoc, `<div swg-teaser=""><p>This is some seriously … <a href="/subscribe">Subscribe</a></p></div><script type="application/octet-stream" ciphertext="">`) {
+		t.Errorf("Missing teaser: %s", encDoc)
+	}
+	reencDoc, err := GenerateEncryptedDocumentWithOptions(encDoc, ar, recipients, EncryptOptions{
+		EncryptedSections: EncryptedSectionsReencrypt,
+		Unwrapper:         u,
+		UnwrapDomain:      "local",
+		Teaser:            opts.Teaser,
+	})
+	if err != nil {
+		t.Fatalf("Failed to re-encrypt document: %v", err)
+	}
+	if n := strings.Count(reencDoc, teaserAttr); n != 1 {
+		t.Errorf("Got %d teasers after re-encryption; want 1", n)
+	}
+}
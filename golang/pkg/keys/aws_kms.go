@@ -0,0 +1,65 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Original author: Apoorv Mote https://apoorv.blog
+ */
+package keys
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/tink/go/integration/awskms"
+	"github.com/google/tink/go/tink"
+)
+
+// Wraps private keysets with a key hosted on AWS KMS.
+type awsKMSBackend struct {
+	region  *string
+	account *string
+	key     *string
+}
+
+func init() {
+	RegisterBackend(&awsKMSBackend{})
+}
+
+func (b *awsKMSBackend) Name() string {
+	return "aws-kms"
+}
+
+func (b *awsKMSBackend) AddFlags(fs *flag.FlagSet) {
+	b.region = fs.String("aws_region", "us-east-1", "AWS Region for created key")
+	b.account = fs.String("aws_account", "", "AWS account ID")
+	b.key = fs.String("aws_key", "", "AWS KMS Key ID")
+}
+
+func (b *awsKMSBackend) KeyURI() (string, error) {
+	if b.account == nil || *b.region == "" || *b.account == "" || *b.key == "" {
+		return "", errors.New("aws-kms requires aws_region, aws_account and aws_key.")
+	}
+	return fmt.Sprintf(
+		"aws-kms://arn:aws:kms:%s:%s:key/%s",
+		*b.region,
+		*b.account,
+		*b.key), nil
+}
+
+func (b *awsKMSBackend) MasterKey(keyURI string) (tink.AEAD, error) {
+	client, err := awskms.NewClient(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	return NewEnvelopeAEAD(client, keyURI)
+}
@@ -0,0 +1,129 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"errors"
+	"flag"
+	"github.com/google/tink/go/aead"
+	"github.com/google/tink/go/core/registry"
+	"github.com/google/tink/go/tink"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Helper functions to select the master key that wraps private keysets.
+
+// A Backend builds the master key URI for one kind of key store and returns
+// the AEAD used to wrap private keysets with that master key.
+type Backend interface {
+	// Returns the name selecting the backend, which is also its URI scheme.
+	Name() string
+	// Registers the backend specific flags on the input FlagSet.
+	AddFlags(fs *flag.FlagSet)
+	// Builds the master key URI from the backend specific flags.
+	KeyURI() (string, error)
+	// Returns the AEAD wrapping private keysets under the input key URI. A nil
+	// AEAD means the keyset is stored in cleartext.
+	MasterKey(keyURI string) (tink.AEAD, error)
+}
+
+var (
+	backendsMu sync.Mutex
+	backends   = make(map[string]Backend)
+)
+
+// Public function to make a Backend available by name and URI scheme.
+func RegisterBackend(b Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[b.Name()] = b
+}
+
+// Public function to list the names of all registered backends.
+func Backends() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	return backendNamesLocked()
+}
+
+// Public function to retrieve a registered Backend by name.
+func GetBackend(name string) (Backend, error) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	b, ok := backends[name]
+	if !ok {
+		return nil, errors.New("Unknown key backend: " + name + ". Supported: " + strings.Join(backendNamesLocked(), ", "))
+	}
+	return b, nil
+}
+
+// Public function to retrieve the registered Backend for a key URI's scheme.
+func BackendForURI(keyURI string) (Backend, error) {
+	i := strings.Index(keyURI, "://")
+	if i <= 0 {
+		return nil, errors.New("Key URI has no scheme: " + keyURI)
+	}
+	return GetBackend(keyURI[:i])
+}
+
+// Public function to select a Backend and master key URI from either a full
+// key URI or a backend name and its flags. When both are given they must
+// agree.
+func ResolveBackend(name string, keyURI string) (Backend, string, error) {
+	if keyURI != "" {
+		b, err := BackendForURI(keyURI)
+		if err != nil {
+			return nil, "", err
+		}
+		if name != "" && name != b.Name() {
+			return nil, "", errors.New("Key URI " + keyURI + " does not match backend " + name + ".")
+		}
+		return b, keyURI, nil
+	}
+	if name == "" {
+		return nil, "", errors.New("Either a backend or a key URI must be given.")
+	}
+	b, err := GetBackend(name)
+	if err != nil {
+		return nil, "", err
+	}
+	keyURI, err = b.KeyURI()
+	if err != nil {
+		return nil, "", err
+	}
+	return b, keyURI, nil
+}
+
+// Public function to create a KMS envelope AEAD using the input client. Each
+// wrapped keyset is encrypted with a fresh data key which is itself encrypted
+// by the remote key at keyURI.
+func NewEnvelopeAEAD(client registry.KMSClient, keyURI string) (tink.AEAD, error) {
+	remote, err := client.GetAEAD(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	return aead.NewKMSEnvelopeAEAD(*aead.AES128CTRHMACSHA256KeyTemplate(), remote), nil
+}
+
+func backendNamesLocked() []string {
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,54 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"testing"
+)
+
+func TestResolveBackendByURI(t *testing.T) {
+	b, uri, err := ResolveBackend("", "local-file://")
+	if err != nil {
+		t.Fatalf("Failed to resolve backend: %v", err)
+	}
+	if b.Name() != "local-file" || uri != "local-file://" {
+		t.Errorf("Resolved %s, %s; want local-file, local-file://", b.Name(), uri)
+	}
+}
+
+func TestResolveBackendByName(t *testing.T) {
+	b, uri, err := ResolveBackend("local-file", "")
+	if err != nil {
+		t.Fatalf("Failed to resolve backend: %v", err)
+	}
+	if b.Name() != "local-file" || uri != localFileKeyURI {
+		t.Errorf("Resolved %s, %s; want local-file, %s", b.Name(), uri, localFileKeyURI)
+	}
+}
+
+func TestResolveBackendMismatch(t *testing.T) {
+	if _, _, err := ResolveBackend("gcp-kms", "local-file://"); err == nil {
+		t.Errorf("Expected failure when backend and key URI disagree.")
+	}
+}
+
+func TestResolveBackendUnknown(t *testing.T) {
+	if _, _, err := ResolveBackend("", "vault://key"); err == nil {
+		t.Errorf("Expected failure on unknown scheme.")
+	}
+	if _, _, err := ResolveBackend("", ""); err == nil {
+		t.Errorf("Expected failure without backend or key URI.")
+	}
+}
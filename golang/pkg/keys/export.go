@@ -0,0 +1,355 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/keyset"
+	eciespb "github.com/google/tink/go/proto/ecies_aead_hkdf_go_proto"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"math/big"
+	"strconv"
+)
+
+// Helper functions to exchange ECIES public keys with tools that do not use
+// Tink.
+
+// Public keyset export formats.
+const (
+	PublicKeyFormatTink string = "tink"
+	PublicKeyFormatPEM  string = "pem"
+	PublicKeyFormatJWK  string = "jwk"
+)
+
+// A JSON Web Key holding an EC public key. A JWK cannot express how Tink
+// derives the DEM key, so the ECIES parameters are added as private "tink_"
+// members; JWK consumers ignore members they do not understand.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	// HKDF hash, such as "SHA256".
+	HKDFHash string `json:"tink_hkdf_hash,omitempty"`
+	// Base64url encoded HKDF salt, empty for the default templates.
+	HKDFSalt string `json:"tink_hkdf_salt,omitempty"`
+	// DEM used with the derived key, such as "AES128-GCM".
+	DEM string `json:"tink_dem,omitempty"`
+	// Encoding of the ephemeral public point in ciphertexts.
+	PointFormat string `json:"tink_point_format,omitempty"`
+	// Tink output prefix of ciphertexts: TINK or RAW.
+	OutputPrefixType string `json:"tink_output_prefix,omitempty"`
+}
+
+// A JSON Web Key Set.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// An enabled ECIES key of a public keyset.
+type exportedKey struct {
+	report KeyReport
+	pub    *ecdsa.PublicKey
+}
+
+// Public function to export the enabled keys of a public keyset in the input
+// format: Tink JSON, PEM encoded SubjectPublicKeyInfo or a JWK Set. Each PEM
+// block is preceded by a note of the HKDF and DEM parameters needed to
+// encrypt to the key.
+func ExportPublicKeyset(ks *tinkpb.Keyset, format string) ([]byte, error) {
+	if format == PublicKeyFormatTink {
+		if _, err := InspectPublicKeyset(ks); err != nil {
+			return nil, err
+		}
+		buf := new(bytes.Buffer)
+		if err := keyset.NewJSONWriter(buf).Write(ks); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	keys, err := exportableKeys(ks)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case PublicKeyFormatPEM:
+		buf := new(bytes.Buffer)
+		for _, k := range keys {
+			der, err := x509.MarshalPKIXPublicKey(k.pub)
+			if err != nil {
+				return nil, err
+			}
+			for _, line := range eciesNote(k.report) {
+				fmt.Fprintln(buf, line)
+			}
+			if err := pem.Encode(buf, &pem.Block{Type: "PUBLIC KEY", Bytes: der}); err != nil {
+				return nil, err
+			}
+		}
+		return buf.Bytes(), nil
+	case PublicKeyFormatJWK:
+		set := JWKSet{}
+		for _, k := range keys {
+			set.Keys = append(set.Keys, newJWK(k))
+		}
+		b, err := json.MarshalIndent(set, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	}
+	return nil, errors.New("Unknown public key format: " + format)
+}
+
+// Public function to import an external EC public key, given as PEM encoded
+// SubjectPublicKeyInfo or as a JWK or JWK Set holding one key, into a Tink public keyset usable by the
+// encrypt script. The ECIES parameters are taken from the named key template
+// and point format, and the key curve must match the template. Ciphertexts
+// of the imported key carry no Tink output prefix.
+func ImportPublicKey(b []byte, templateName string, pointFormat string) (*tinkpb.Keyset, error) {
+	ecKey, err := parseExternalPublicKey(b)
+	if err != nil {
+		return nil, err
+	}
+	kt, err := KeyTemplate(templateName, pointFormat)
+	if err != nil {
+		return nil, err
+	}
+	format := &eciespb.EciesAeadHkdfKeyFormat{}
+	if err := proto.Unmarshal(kt.Value, format); err != nil {
+		return nil, err
+	}
+	pub := &eciespb.EciesAeadHkdfPublicKey{Params: format.Params}
+	want, err := eciesPublicKeyToECDSA(pub)
+	if err != nil {
+		return nil, err
+	}
+	if want.Curve.Params().Name != ecKey.Curve.Params().Name {
+		return nil, errors.New("Key curve " + ecKey.Curve.Params().Name + " does not match template " + templateName + ".")
+	}
+	size := (ecKey.Curve.Params().BitSize + 7) / 8
+	pub.X = padCoordinate(ecKey.X, size)
+	pub.Y = padCoordinate(ecKey.Y, size)
+	value, err := proto.Marshal(pub)
+	if err != nil {
+		return nil, err
+	}
+	ks := &tinkpb.Keyset{}
+	keyID, err := newKeyID(ks)
+	if err != nil {
+		return nil, err
+	}
+	ks.PrimaryKeyId = keyID
+	ks.Key = []*tinkpb.Keyset_Key{{
+		KeyData: &tinkpb.KeyData{
+			TypeUrl:         eciesPublicKeyURL,
+			Value:           value,
+			KeyMaterialType: tinkpb.KeyData_ASYMMETRIC_PUBLIC,
+		},
+		Status:           tinkpb.KeyStatusType_ENABLED,
+		KeyId:            keyID,
+		OutputPrefixType: tinkpb.OutputPrefixType_RAW,
+	}}
+	if err := keyset.Validate(ks); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Public function to return the EC public key of the ECIES key with the
+// input ID, for use with other ECDH based schemes such as JWE.
+func ECIESPublicKey(ks *tinkpb.Keyset, keyID uint32) (*ecdsa.PublicKey, error) {
+	k, err := findKey(ks, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if k.KeyData == nil || k.KeyData.TypeUrl != eciesPublicKeyURL {
+		return nil, fmt.Errorf("Key %d is not an ECIES public key.", keyID)
+	}
+	pub := &eciespb.EciesAeadHkdfPublicKey{}
+	if err := proto.Unmarshal(k.KeyData.Value, pub); err != nil {
+		return nil, err
+	}
+	return eciesPublicKeyToECDSA(pub)
+}
+
+// Returns the enabled keys of a public keyset, which must all be ECIES keys.
+func exportableKeys(ks *tinkpb.Keyset) ([]exportedKey, error) {
+	report, err := InspectPublicKeyset(ks)
+	if err != nil {
+		return nil, err
+	}
+	var keys []exportedKey
+	for i, k := range ks.Key {
+		if k.Status != tinkpb.KeyStatusType_ENABLED {
+			continue
+		}
+		if k.KeyData.TypeUrl != eciesPublicKeyURL {
+			return nil, fmt.Errorf("Key %d is not an ECIES key.", k.KeyId)
+		}
+		pub := &eciespb.EciesAeadHkdfPublicKey{}
+		if err := proto.Unmarshal(k.KeyData.Value, pub); err != nil {
+			return nil, err
+		}
+		ecKey, err := eciesPublicKeyToECDSA(pub)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, exportedKey{report: report.Keys[i], pub: ecKey})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("The keyset has no enabled keys to export.")
+	}
+	return keys, nil
+}
+
+// Describes how Tink encrypts to an ECIES key, for the text preceding a PEM
+// block.
+func eciesNote(k KeyReport) []string {
+	id := strconv.FormatUint(uint64(k.KeyID), 10)
+	primary := ""
+	if k.Primary {
+		primary = " (primary)"
+	}
+	salt := "empty"
+	if k.HKDFSalt != "" {
+		salt = k.HKDFSalt + " (hex)"
+	}
+	prefix := ""
+	if k.OutputPrefixType == tinkpb.OutputPrefixType_TINK.String() {
+		prefix = "0x01 || key ID (4 bytes, big endian) || "
+	}
+	return []string{
+		"Tink ECIES-AEAD-HKDF public key " + id + primary,
+		"Curve: " + k.Curve + ", ephemeral point format: " + k.PointFormat,
+		"KEM: HKDF-HMAC-" + k.HKDFHash + ", salt " + salt + ", IKM ephemeral point || ECDH shared secret, info empty",
+		"DEM: " + k.DEM + ", keyed with HKDF output of the DEM key size",
+		"Ciphertext: " + prefix + "ephemeral point || DEM ciphertext",
+	}
+}
+
+// Converts an ECIES key to a JWK.
+func newJWK(k exportedKey) JWK {
+	size := (k.pub.Curve.Params().BitSize + 7) / 8
+	j := JWK{
+		Kty:              "EC",
+		Crv:              k.pub.Curve.Params().Name,
+		X:                base64.RawURLEncoding.EncodeToString(padCoordinate(k.pub.X, size)),
+		Y:                base64.RawURLEncoding.EncodeToString(padCoordinate(k.pub.Y, size)),
+		Kid:              strconv.FormatUint(uint64(k.report.KeyID), 10),
+		Use:              "enc",
+		HKDFHash:         k.report.HKDFHash,
+		DEM:              k.report.DEM,
+		PointFormat:      k.report.PointFormat,
+		OutputPrefixType: k.report.OutputPrefixType,
+	}
+	if salt, err := hex.DecodeString(k.report.HKDFSalt); err == nil {
+		j.HKDFSalt = base64.RawURLEncoding.EncodeToString(salt)
+	}
+	return j
+}
+
+// Parses a PEM encoded SubjectPublicKeyInfo or a JWK holding an EC public
+// key.
+func parseExternalPublicKey(b []byte) (*ecdsa.PublicKey, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '{' {
+		// Either a single JWK or a JWK Set holding one key.
+		var j struct {
+			JWK
+			Keys []JWK `json:"keys"`
+		}
+		if err := json.Unmarshal(b, &j); err != nil {
+			return nil, err
+		}
+		switch len(j.Keys) {
+		case 0:
+			return j.JWK.PublicKey()
+		case 1:
+			return j.Keys[0].PublicKey()
+		}
+		return nil, errors.New("The JWK Set holds more than one key.")
+	}
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return nil, errors.New("No PEM encoded public key found.")
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		ecKey, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, errors.New("The PEM encoded public key is not an EC key.")
+		}
+		return ecKey, nil
+	}
+}
+
+// Public function to decode the EC public key of a JWK.
+func (j *JWK) PublicKey() (*ecdsa.PublicKey, error) {
+	if j.Kty != "EC" {
+		return nil, errors.New("Unsupported JWK key type: " + j.Kty)
+	}
+	var curve elliptic.Curve
+	switch j.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, errors.New("Unsupported JWK curve: " + j.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(j.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(j.Y)
+	if err != nil {
+		return nil, err
+	}
+	pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("The JWK point is not on curve " + j.Crv + ".")
+	}
+	return pub, nil
+}
+
+// Encodes a curve coordinate as a fixed size big endian integer.
+func padCoordinate(n *big.Int, size int) []byte {
+	b := make([]byte, size)
+	nb := n.Bytes()
+	copy(b[size-len(nb):], nb)
+	return b
+}
@@ -0,0 +1,151 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"encoding/json"
+	"github.com/google/tink/go/hybrid"
+	"github.com/google/tink/go/keyset"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"strings"
+	"testing"
+)
+
+// Returns the public keyset of a handle.
+func publicTestKeyset(t *testing.T, kh *keyset.Handle) *tinkpb.Keyset {
+	pub, err := kh.Public()
+	if err != nil {
+		t.Fatalf("Failed to get public keyset: %v", err)
+	}
+	mem := &keyset.MemReaderWriter{}
+	if err := pub.WriteWithNoSecrets(mem); err != nil {
+		t.Fatalf("Failed to write public keyset: %v", err)
+	}
+	return mem.Keyset
+}
+
+// Checks that a ciphertext of the imported keyset decrypts with the private
+// keyset it was exported from.
+func checkImportedKeyset(t *testing.T, imported *tinkpb.Keyset, kh *keyset.Handle) {
+	pub, err := keyset.NewHandleWithNoSecrets(imported)
+	if err != nil {
+		t.Fatalf("Failed to read imported keyset: %v", err)
+	}
+	he, err := hybrid.NewHybridEncrypt(pub)
+	if err != nil {
+		t.Fatalf("Failed to create encrypter: %v", err)
+	}
+	ct, err := he.Encrypt([]byte("document key"), nil)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	// Imported keys produce ciphertexts without output prefix.
+	ks, err := exportKeyset(kh)
+	if err != nil {
+		t.Fatalf("Failed to export keyset: %v", err)
+	}
+	ks.Key[0].OutputPrefixType = tinkpb.OutputPrefixType_RAW
+	raw, err := importKeyset(ks)
+	if err != nil {
+		t.Fatalf("Failed to import keyset: %v", err)
+	}
+	hd, err := hybrid.NewHybridDecrypt(raw)
+	if err != nil {
+		t.Fatalf("Failed to create decrypter: %v", err)
+	}
+	pt, err := hd.Decrypt(ct, nil)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	if string(pt) != "document key" {
+		t.Errorf("Got plaintext %q", pt)
+	}
+}
+
+func TestExportImportPEM(t *testing.T) {
+	kh := newTestKeyset(t)
+	b, err := ExportPublicKeyset(publicTestKeyset(t, kh), PublicKeyFormatPEM)
+	if err != nil {
+		t.Fatalf("Failed to export PEM: %v", err)
+	}
+	if !strings.Contains(string(b), "-----BEGIN PUBLIC KEY-----") || !strings.Contains(string(b), "HKDF-HMAC-SHA256") {
+		t.Errorf("Unexpected PEM export: %s", b)
+	}
+	imported, err := ImportPublicKey(b, DefaultKeyTemplate, "")
+	if err != nil {
+		t.Fatalf("Failed to import PEM: %v", err)
+	}
+	checkImportedKeyset(t, imported, kh)
+}
+
+func TestExportImportJWK(t *testing.T) {
+	kh := newTestKeyset(t)
+	b, err := ExportPublicKeyset(publicTestKeyset(t, kh), PublicKeyFormatJWK)
+	if err != nil {
+		t.Fatalf("Failed to export JWK: %v", err)
+	}
+	var set JWKSet
+	if err := json.Unmarshal(b, &set); err != nil {
+		t.Fatalf("Failed to parse JWK Set: %v", err)
+	}
+	if len(set.Keys) != 1 {
+		t.Fatalf("Got %d keys; want 1", len(set.Keys))
+	}
+	j := set.Keys[0]
+	if j.Kty != "EC" || j.Crv != "P-256" || j.DEM != "AES128-GCM" || j.HKDFHash != "SHA256" {
+		t.Errorf("Unexpected JWK: %+v", j)
+	}
+	jb, err := json.Marshal(j)
+	if err != nil {
+		t.Fatalf("Failed to marshal JWK: %v", err)
+	}
+	imported, err := ImportPublicKey(jb, DefaultKeyTemplate, "")
+	if err != nil {
+		t.Fatalf("Failed to import JWK: %v", err)
+	}
+	checkImportedKeyset(t, imported, kh)
+	if _, err := ImportPublicKey(b, DefaultKeyTemplate, ""); err != nil {
+		t.Errorf("Failed to import JWK Set: %v", err)
+	}
+}
+
+func TestImportPublicKeyRejectsMismatches(t *testing.T) {
+	kh := newTestKeyset(t)
+	b, err := ExportPublicKeyset(publicTestKeyset(t, kh), PublicKeyFormatPEM)
+	if err != nil {
+		t.Fatalf("Failed to export PEM: %v", err)
+	}
+	if _, err := ImportPublicKey(b, "ECIES_P384_HKDF_HMAC_SHA384_AES128_GCM", ""); err == nil {
+		t.Errorf("Expected failure on curve mismatch.")
+	}
+	offCurve := `{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}`
+	if _, err := ImportPublicKey([]byte(offCurve), DefaultKeyTemplate, ""); err == nil {
+		t.Errorf("Expected failure on point off the curve.")
+	}
+}
+
+func TestECIESPublicKey(t *testing.T) {
+	ks := publicTestKeyset(t, newTestKeyset(t))
+	pub, err := ECIESPublicKey(ks, ks.PrimaryKeyId)
+	if err != nil {
+		t.Fatalf("Failed to get EC public key: %v", err)
+	}
+	if pub.Curve.Params().Name != "P-256" || !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		t.Errorf("Unexpected EC public key: %+v", pub)
+	}
+	if _, err := ECIESPublicKey(ks, ks.PrimaryKeyId+1); err == nil {
+		t.Errorf("Expected failure on unknown key ID.")
+	}
+}
@@ -0,0 +1,70 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/tink/go/integration/gcpkms"
+	"github.com/google/tink/go/tink"
+)
+
+// Wraps private keysets with a key hosted on Google Cloud KMS.
+type gcpKMSBackend struct {
+	project  *string
+	location *string
+	keyring  *string
+	key      *string
+}
+
+func init() {
+	RegisterBackend(&gcpKMSBackend{})
+}
+
+func (b *gcpKMSBackend) Name() string {
+	return "gcp-kms"
+}
+
+func (b *gcpKMSBackend) AddFlags(fs *flag.FlagSet) {
+	b.project = fs.String("gcp_project", "", "GCP Project ID")
+	b.location = fs.String("gcp_location", "", "GCP Keyring Location")
+	b.keyring = fs.String("gcp_keyring", "", "GCP Keyring ID")
+	b.key = fs.String("gcp_key", "", "GCP Key ID")
+}
+
+func (b *gcpKMSBackend) KeyURI() (string, error) {
+	if b.project == nil || *b.project == "" || *b.location == "" || *b.keyring == "" || *b.key == "" {
+		return "", errors.New("gcp-kms requires gcp_project, gcp_location, gcp_keyring and gcp_key.")
+	}
+	return fmt.Sprintf(
+		"gcp-kms://projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s",
+		*b.project,
+		*b.location,
+		*b.keyring,
+		*b.key), nil
+}
+
+func (b *gcpKMSBackend) MasterKey(keyURI string) (tink.AEAD, error) {
+	client, err := gcpkms.NewGCPClient(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	// Looks for credentials JSON file in GOOGLE_APPLICATION_CREDENTIALS variable.
+	if _, err := client.LoadDefaultCredentials(); err != nil {
+		return nil, err
+	}
+	return NewEnvelopeAEAD(client, keyURI)
+}
@@ -0,0 +1,206 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/keyset"
+	ctrhmacpb "github.com/google/tink/go/proto/aes_ctr_hmac_aead_go_proto"
+	gcmpb "github.com/google/tink/go/proto/aes_gcm_go_proto"
+	commonpb "github.com/google/tink/go/proto/common_go_proto"
+	eciespb "github.com/google/tink/go/proto/ecies_aead_hkdf_go_proto"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"math/big"
+	"strings"
+)
+
+// Helper functions to describe keysets without revealing secret material.
+
+const eciesPublicKeyURL string = "type.googleapis.com/google.crypto.tink.EciesAeadHkdfPublicKey"
+const aesGCMKeyURL string = "type.googleapis.com/google.crypto.tink.AesGcmKey"
+const aesCTRHMACAEADKeyURL string = "type.googleapis.com/google.crypto.tink.AesCtrHmacAeadKey"
+
+// Describes a single public key.
+type KeyReport struct {
+	KeyID            uint32
+	Primary          bool
+	Status           string
+	OutputPrefixType string
+	TypeURL          string
+	// ECIES parameters, empty for other key types.
+	Curve       string
+	HKDFHash    string
+	HKDFSalt    string
+	PointFormat string
+	DEM         string
+	// SHA-256 of the DER encoded SubjectPublicKeyInfo for ECIES keys, or of
+	// the serialized key data otherwise.
+	Fingerprint string
+}
+
+// Describes a public keyset.
+type KeysetReport struct {
+	PrimaryKeyID uint32
+	Keys         []KeyReport
+}
+
+// Public function to describe a public keyset. Keysets holding secret key
+// material are rejected.
+func InspectPublicKeyset(ks *tinkpb.Keyset) (*KeysetReport, error) {
+	r := &KeysetReport{PrimaryKeyID: ks.PrimaryKeyId}
+	for _, k := range ks.Key {
+		if k.KeyData == nil {
+			return nil, fmt.Errorf("Key %d has no key data.", k.KeyId)
+		}
+		if k.KeyData.KeyMaterialType != tinkpb.KeyData_ASYMMETRIC_PUBLIC {
+			return nil, fmt.Errorf("Key %d is not a public key.", k.KeyId)
+		}
+		kr := KeyReport{
+			KeyID:            k.KeyId,
+			Primary:          k.KeyId == ks.PrimaryKeyId,
+			Status:           k.Status.String(),
+			OutputPrefixType: k.OutputPrefixType.String(),
+			TypeURL:          k.KeyData.TypeUrl,
+		}
+		sum := sha256.Sum256(k.KeyData.Value)
+		kr.Fingerprint = hex.EncodeToString(sum[:])
+		if k.KeyData.TypeUrl == eciesPublicKeyURL {
+			if err := describeECIESKey(k.KeyData.Value, &kr); err != nil {
+				return nil, fmt.Errorf("Key %d: %v", k.KeyId, err)
+			}
+		}
+		r.Keys = append(r.Keys, kr)
+	}
+	return r, nil
+}
+
+// Public function to describe the public half of a private keyset. Only the
+// public keys are ever looked at.
+func InspectPrivateKeyset(kh *keyset.Handle) (*KeysetReport, error) {
+	pub, err := kh.Public()
+	if err != nil {
+		return nil, err
+	}
+	mem := &keyset.MemReaderWriter{}
+	if err := pub.WriteWithNoSecrets(mem); err != nil {
+		return nil, err
+	}
+	return InspectPublicKeyset(mem.Keyset)
+}
+
+// Renders the report as human readable text.
+func (r *KeysetReport) String() string {
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "Primary key ID: %d\n", r.PrimaryKeyID)
+	for _, k := range r.Keys {
+		primary := ""
+		if k.Primary {
+			primary = " (primary)"
+		}
+		fmt.Fprintf(b, "\nKey ID: %d%s\n", k.KeyID, primary)
+		fmt.Fprintf(b, "  Status:             %s\n", k.Status)
+		fmt.Fprintf(b, "  Output prefix type: %s\n", k.OutputPrefixType)
+		fmt.Fprintf(b, "  Type URL:           %s\n", k.TypeURL)
+		if k.Curve != "" {
+			fmt.Fprintf(b, "  Curve:              %s\n", k.Curve)
+			fmt.Fprintf(b, "  HKDF hash:          %s\n", k.HKDFHash)
+			if k.HKDFSalt != "" {
+				fmt.Fprintf(b, "  HKDF salt:          %s\n", k.HKDFSalt)
+			}
+			fmt.Fprintf(b, "  Point format:       %s\n", k.PointFormat)
+			fmt.Fprintf(b, "  DEM:                %s\n", k.DEM)
+		}
+		fmt.Fprintf(b, "  Fingerprint:        SHA256:%s\n", k.Fingerprint)
+	}
+	return b.String()
+}
+
+// Fills in the ECIES parameters of a serialized EciesAeadHkdfPublicKey.
+func describeECIESKey(value []byte, kr *KeyReport) error {
+	pub := &eciespb.EciesAeadHkdfPublicKey{}
+	if err := proto.Unmarshal(value, pub); err != nil {
+		return err
+	}
+	params := pub.GetParams()
+	kr.Curve = params.GetKemParams().GetCurveType().String()
+	kr.HKDFHash = params.GetKemParams().GetHkdfHashType().String()
+	kr.HKDFSalt = hex.EncodeToString(params.GetKemParams().GetHkdfSalt())
+	kr.PointFormat = params.GetEcPointFormat().String()
+	kr.DEM = describeDEM(params.GetDemParams().GetAeadDem())
+	ecKey, err := eciesPublicKeyToECDSA(pub)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKIXPublicKey(ecKey)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(der)
+	kr.Fingerprint = hex.EncodeToString(sum[:])
+	return nil
+}
+
+// Describes an ECIES DEM key template, such as "AES128-GCM".
+func describeDEM(kt *tinkpb.KeyTemplate) string {
+	if kt == nil {
+		return "none"
+	}
+	switch kt.TypeUrl {
+	case aesGCMKeyURL:
+		f := &gcmpb.AesGcmKeyFormat{}
+		if err := proto.Unmarshal(kt.Value, f); err == nil {
+			return fmt.Sprintf("AES%d-GCM", f.KeySize*8)
+		}
+	case aesCTRHMACAEADKeyURL:
+		f := &ctrhmacpb.AesCtrHmacAeadKeyFormat{}
+		if err := proto.Unmarshal(kt.Value, f); err == nil {
+			hmacParams := f.GetHmacKeyFormat().GetParams()
+			return fmt.Sprintf("AES%d-CTR-HMAC-%s (%d byte IV, %d byte tag)",
+				f.GetAesCtrKeyFormat().GetKeySize()*8,
+				hmacParams.GetHash(),
+				f.GetAesCtrKeyFormat().GetParams().GetIvSize(),
+				hmacParams.GetTagSize())
+		}
+	}
+	return kt.TypeUrl[strings.LastIndex(kt.TypeUrl, ".")+1:]
+}
+
+// Converts the public point of an ECIES key to an ecdsa.PublicKey.
+func eciesPublicKeyToECDSA(pub *eciespb.EciesAeadHkdfPublicKey) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch pub.GetParams().GetKemParams().GetCurveType() {
+	case commonpb.EllipticCurveType_NIST_P256:
+		curve = elliptic.P256()
+	case commonpb.EllipticCurveType_NIST_P384:
+		curve = elliptic.P384()
+	case commonpb.EllipticCurveType_NIST_P521:
+		curve = elliptic.P521()
+	default:
+		return nil, errors.New("Unsupported curve: " + pub.GetParams().GetKemParams().GetCurveType().String())
+	}
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(pub.X),
+		Y:     new(big.Int).SetBytes(pub.Y),
+	}, nil
+}
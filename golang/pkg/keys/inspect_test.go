@@ -0,0 +1,82 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"github.com/google/tink/go/keyset"
+	"strings"
+	"testing"
+)
+
+func TestInspectPrivateKeyset(t *testing.T) {
+	kh := newTestKeyset(t)
+	r, err := InspectPrivateKeyset(kh)
+	if err != nil {
+		t.Fatalf("Failed to inspect keyset: %v", err)
+	}
+	if len(r.Keys) != 1 {
+		t.Fatalf("Got %d keys; want 1", len(r.Keys))
+	}
+	k := r.Keys[0]
+	if !k.Primary || k.KeyID != r.PrimaryKeyID {
+		t.Errorf("Key %d should be primary.", k.KeyID)
+	}
+	if k.Curve != "NIST_P256" || k.HKDFHash != "SHA256" || k.PointFormat != "UNCOMPRESSED" || k.DEM != "AES128-GCM" {
+		t.Errorf("Unexpected ECIES parameters: %+v", k)
+	}
+	if k.Status != "ENABLED" || k.OutputPrefixType != "TINK" || k.TypeURL != eciesPublicKeyURL {
+		t.Errorf("Unexpected key info: %+v", k)
+	}
+	if len(k.Fingerprint) != 64 {
+		t.Errorf("Unexpected fingerprint: %s", k.Fingerprint)
+	}
+	if !strings.Contains(r.String(), "SHA256:"+k.Fingerprint) {
+		t.Errorf("Fingerprint missing from report: %s", r.String())
+	}
+}
+
+func TestInspectPublicKeysetRejectsSecrets(t *testing.T) {
+	kh := newTestKeyset(t)
+	ks, err := exportKeyset(kh)
+	if err != nil {
+		t.Fatalf("Failed to export keyset: %v", err)
+	}
+	if _, err := InspectPublicKeyset(ks); err == nil {
+		t.Errorf("Expected failure inspecting private key material.")
+	}
+}
+
+func TestInspectPublicAndPrivateFingerprintsMatch(t *testing.T) {
+	kh := newTestKeyset(t)
+	pub, err := kh.Public()
+	if err != nil {
+		t.Fatalf("Failed to get public keyset: %v", err)
+	}
+	mem := &keyset.MemReaderWriter{}
+	if err := pub.WriteWithNoSecrets(mem); err != nil {
+		t.Fatalf("Failed to export public keyset: %v", err)
+	}
+	pr, err := InspectPublicKeyset(mem.Keyset)
+	if err != nil {
+		t.Fatalf("Failed to inspect public keyset: %v", err)
+	}
+	kr, err := InspectPrivateKeyset(kh)
+	if err != nil {
+		t.Fatalf("Failed to inspect private keyset: %v", err)
+	}
+	if pr.Keys[0].Fingerprint != kr.Keys[0].Fingerprint {
+		t.Errorf("Fingerprints differ: %s != %s", pr.Keys[0].Fingerprint, kr.Keys[0].Fingerprint)
+	}
+}
@@ -0,0 +1,82 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"bytes"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/keyset"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// Helper functions to generate the Tink Hybrid keys used to encrypt document
+// keys.
+
+// Public function to generate a new Tink Hybrid private keyset from the
+// input key template.
+func GenerateKeyset(kt *tinkpb.KeyTemplate) (*keyset.Handle, error) {
+	return keyset.NewHandle(kt)
+}
+
+// Public function to check the output paths of a key generation run.
+func ValidateOutputPaths(privatePath string, publicPath string) error {
+	if privatePath == "" {
+		return errors.New("Missing output file for private key.")
+	}
+	if publicPath == "" {
+		return errors.New("Missing output file for public key.")
+	}
+	if filepath.Clean(privatePath) == filepath.Clean(publicPath) {
+		return errors.New("Private and public keys must be written to different files.")
+	}
+	return nil
+}
+
+// Public function to write the public half of the keyset to the input path
+// in Tink JSON format. The file is read back after writing to check that it
+// holds the public keyset. An existing file is only replaced if overwrite is
+// true.
+func WritePublicKeyset(kh *keyset.Handle, path string, overwrite bool) error {
+	khPub, err := kh.Public()
+	if err != nil {
+		return err
+	}
+	mem := &keyset.MemReaderWriter{}
+	if err := khPub.WriteWithNoSecrets(mem); err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	if err := keyset.NewJSONWriter(buf).Write(mem.Keyset); err != nil {
+		return err
+	}
+	if err := WriteKeyFile(path, buf.Bytes(), PublicKeyFileMode, overwrite); err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	ks, err := keyset.NewJSONReader(bytes.NewReader(b)).Read()
+	if err != nil {
+		return err
+	}
+	if !proto.Equal(ks, mem.Keyset) {
+		return errors.New("Public keyset read back from " + path + " does not match the written keyset.")
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"github.com/google/tink/go/keyset"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func defaultTestTemplate(t *testing.T) *tinkpb.KeyTemplate {
+	kt, err := KeyTemplate(DefaultKeyTemplate, "")
+	if err != nil {
+		t.Fatalf("Failed to build key template: %v", err)
+	}
+	return kt
+}
+
+// Generates a new keyset from the default template.
+func newTestKeyset(t *testing.T) *keyset.Handle {
+	kh, err := GenerateKeyset(defaultTestTemplate(t))
+	if err != nil {
+		t.Fatalf("Failed to generate keyset: %v", err)
+	}
+	return kh
+}
+
+func TestValidateOutputPaths(t *testing.T) {
+	if err := ValidateOutputPaths("priv", "pub"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := ValidateOutputPaths("", "pub"); err == nil {
+		t.Errorf("Expected failure on missing private path.")
+	}
+	if err := ValidateOutputPaths("dir/key", "dir/./key"); err == nil {
+		t.Errorf("Expected failure on identical paths.")
+	}
+}
+
+func TestWriteKeysetsCleartext(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	kh := newTestKeyset(t)
+	privPath := filepath.Join(dir, "priv.json")
+	pubPath := filepath.Join(dir, "pub.json")
+	if err := WritePrivateKeyset(kh, nil, localFileKeyURI, PrivateKeysetFormatJSON, privPath, false); err != nil {
+		t.Fatalf("Failed to write private keyset: %v", err)
+	}
+	if err := WritePublicKeyset(kh, pubPath, false); err != nil {
+		t.Fatalf("Failed to write public keyset: %v", err)
+	}
+	if _, _, err := LoadPrivateKeyset(privPath, localFileKeyURI); err != nil {
+		t.Errorf("Failed to read private keyset back: %v", err)
+	}
+	pf, err := os.Open(pubPath)
+	if err != nil {
+		t.Fatalf("Failed to open public keyset: %v", err)
+	}
+	defer pf.Close()
+	if _, err := keyset.ReadWithNoSecrets(keyset.NewJSONReader(pf)); err != nil {
+		t.Errorf("Failed to read public keyset back: %v", err)
+	}
+}
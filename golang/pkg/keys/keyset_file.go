@@ -0,0 +1,285 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/core/cryptofmt"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"github.com/google/tink/go/tink"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Helper functions to store private keysets on disk.
+
+// Private keyset file formats. JSON files wrap a Tink EncryptedKeyset with
+// metadata, binary files hold a bare Tink EncryptedKeyset and legacy files
+// hold base64(AEAD(text proto)) as written by the old key generators.
+const (
+	PrivateKeysetFormatJSON   string = "json"
+	PrivateKeysetFormatBinary string = "binary"
+	PrivateKeysetFormatLegacy string = "legacy"
+)
+
+// A private keyset file along with its metadata.
+type PrivateKeysetFile struct {
+	// Format of the file the keyset was read from.
+	Format string `json:"-"`
+	// URI of the master key wrapping the keyset.
+	KMSURI       string    `json:"kmsUri,omitempty"`
+	Created      time.Time `json:"created"`
+	PrimaryKeyID uint32    `json:"primaryKeyId,omitempty"`
+	KeyIDs       []uint32  `json:"keyIds,omitempty"`
+	// Tink EncryptedKeyset in JSON format.
+	EncryptedKeyset json.RawMessage `json:"encryptedKeyset,omitempty"`
+	// Cleartext Tink keyset in JSON format, only written without master key.
+	Keyset json.RawMessage `json:"keyset,omitempty"`
+	// Payload of binary and legacy files.
+	raw []byte
+	// Master key the keyset was loaded with.
+	masterKey tink.AEAD
+}
+
+// Public function to write the private keyset to the input path, encrypted
+// with the master key at keyURI. A nil master key writes the keyset in
+// cleartext, which is only supported in JSON format. The file is read back
+// and decrypted after writing to check that the master key unwraps it. An
+// existing file is only replaced if overwrite is true.
+func WritePrivateKeyset(kh *keyset.Handle, masterKey tink.AEAD, keyURI string, format string, path string, overwrite bool) error {
+	b, err := EncodePrivateKeyset(kh, masterKey, keyURI, format)
+	if err != nil {
+		return err
+	}
+	if err := WriteKeyFile(path, b, PrivateKeyFileMode, overwrite); err != nil {
+		return err
+	}
+	return verifyPrivateKeysetFile(path, kh, masterKey)
+}
+
+// Checks that the private keyset file at path decrypts to the keyset of kh.
+func verifyPrivateKeysetFile(path string, kh *keyset.Handle, masterKey tink.AEAD) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f, err := ParsePrivateKeysetFile(b)
+	if err != nil {
+		return err
+	}
+	got, err := f.Handle(masterKey)
+	if err != nil {
+		return err
+	}
+	want, err := exportKeyset(kh)
+	if err != nil {
+		return err
+	}
+	gotKs, err := exportKeyset(got)
+	if err != nil {
+		return err
+	}
+	if !proto.Equal(gotKs, want) {
+		return errors.New("Private keyset read back from " + path + " does not match the written keyset.")
+	}
+	return nil
+}
+
+// Public function to serialize the private keyset in the input format.
+func EncodePrivateKeyset(kh *keyset.Handle, masterKey tink.AEAD, keyURI string, format string) ([]byte, error) {
+	f := &PrivateKeysetFile{KMSURI: keyURI, Created: time.Now().UTC()}
+	buf := new(bytes.Buffer)
+	if masterKey == nil {
+		if format != PrivateKeysetFormatJSON {
+			return nil, errors.New("Cleartext private keysets can only be written as JSON.")
+		}
+		mem := &keyset.MemReaderWriter{}
+		if err := insecurecleartextkeyset.Write(kh, mem); err != nil {
+			return nil, err
+		}
+		f.PrimaryKeyID = mem.Keyset.PrimaryKeyId
+		for _, k := range mem.Keyset.Key {
+			f.KeyIDs = append(f.KeyIDs, k.KeyId)
+		}
+		if err := keyset.NewJSONWriter(buf).Write(mem.Keyset); err != nil {
+			return nil, err
+		}
+		f.Keyset = buf.Bytes()
+		return json.MarshalIndent(f, "", "  ")
+	}
+	mem := &keyset.MemReaderWriter{}
+	if err := kh.Write(mem, masterKey); err != nil {
+		return nil, err
+	}
+	switch format {
+	case PrivateKeysetFormatBinary:
+		if err := keyset.NewBinaryWriter(buf).WriteEncrypted(mem.EncryptedKeyset); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case PrivateKeysetFormatJSON:
+		if info := mem.EncryptedKeyset.KeysetInfo; info != nil {
+			f.PrimaryKeyID = info.PrimaryKeyId
+			for _, k := range info.KeyInfo {
+				f.KeyIDs = append(f.KeyIDs, k.KeyId)
+			}
+		}
+		if err := keyset.NewJSONWriter(buf).WriteEncrypted(mem.EncryptedKeyset); err != nil {
+			return nil, err
+		}
+		f.EncryptedKeyset = buf.Bytes()
+		return json.MarshalIndent(f, "", "  ")
+	}
+	return nil, errors.New("Unknown private keyset format: " + format)
+}
+
+// Public function to parse a private keyset file in any supported format
+// without decrypting it.
+func ParsePrivateKeysetFile(b []byte) (*PrivateKeysetFile, error) {
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) == 0 {
+		return nil, errors.New("Private keyset file is empty.")
+	}
+	if trimmed[0] == '{' {
+		f := &PrivateKeysetFile{Format: PrivateKeysetFormatJSON}
+		if err := json.Unmarshal(trimmed, f); err != nil {
+			return nil, err
+		}
+		if len(f.EncryptedKeyset) == 0 && len(f.Keyset) == 0 {
+			return nil, errors.New("Private keyset file holds no keyset.")
+		}
+		return f, nil
+	}
+	if isBase64(trimmed) {
+		raw, err := base64.StdEncoding.DecodeString(string(trimmed))
+		if err != nil {
+			return nil, err
+		}
+		return &PrivateKeysetFile{Format: PrivateKeysetFormatLegacy, raw: raw}, nil
+	}
+	f := &PrivateKeysetFile{Format: PrivateKeysetFormatBinary, raw: b}
+	ks, err := keyset.NewBinaryReader(bytes.NewReader(b)).ReadEncrypted()
+	if err != nil {
+		return nil, err
+	}
+	if info := ks.KeysetInfo; info != nil {
+		f.PrimaryKeyID = info.PrimaryKeyId
+		for _, k := range info.KeyInfo {
+			f.KeyIDs = append(f.KeyIDs, k.KeyId)
+		}
+	}
+	return f, nil
+}
+
+// Decrypts the keyset with the input master key. A nil master key is only
+// accepted for cleartext JSON files.
+func (f *PrivateKeysetFile) Handle(masterKey tink.AEAD) (*keyset.Handle, error) {
+	if len(f.Keyset) != 0 {
+		return insecurecleartextkeyset.Read(keyset.NewJSONReader(bytes.NewReader(f.Keyset)))
+	}
+	if masterKey == nil {
+		return nil, errors.New("A master key is required to decrypt the private keyset.")
+	}
+	switch f.Format {
+	case PrivateKeysetFormatJSON:
+		return keyset.Read(keyset.NewJSONReader(bytes.NewReader(f.EncryptedKeyset)), masterKey)
+	case PrivateKeysetFormatBinary:
+		return keyset.Read(keyset.NewBinaryReader(bytes.NewReader(f.raw)), masterKey)
+	case PrivateKeysetFormatLegacy:
+		text, err := decryptLegacyKeyset(masterKey, f.raw)
+		if err != nil {
+			return nil, err
+		}
+		ks := &tinkpb.Keyset{}
+		if err := proto.UnmarshalText(string(text), ks); err != nil {
+			return nil, err
+		}
+		return insecurecleartextkeyset.Read(&keyset.MemReaderWriter{Keyset: ks})
+	}
+	return nil, errors.New("Unknown private keyset format: " + f.Format)
+}
+
+// Decrypts a legacy keyset. The old key generators encrypted it with a KMS
+// envelope keyset of output prefix type TINK, which prefixes the ciphertext
+// with the 5 byte key prefix; ciphertext without it is decrypted as is.
+func decryptLegacyKeyset(masterKey tink.AEAD, ct []byte) ([]byte, error) {
+	if len(ct) > cryptofmt.NonRawPrefixSize && ct[0] == cryptofmt.TinkStartByte {
+		if text, err := masterKey.Decrypt(ct[cryptofmt.NonRawPrefixSize:], nil); err == nil {
+			return text, nil
+		}
+	}
+	return masterKey.Decrypt(ct, nil)
+}
+
+// Public function to load a private keyset file with the master key at
+// keyURI. The key URI is never taken from the file, which can not be trusted
+// to name its own master key, and cleartext keysets are only read when the
+// caller asks for the local-file backend.
+func LoadPrivateKeyset(path string, keyURI string) (*keyset.Handle, *PrivateKeysetFile, error) {
+	if keyURI == "" {
+		return nil, nil, errors.New("A key URI is required to load the private keyset " + path + ".")
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := ParsePrivateKeysetFile(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.KMSURI != "" && f.KMSURI != keyURI {
+		return nil, nil, errors.New("Key URI " + keyURI + " does not match the file's key URI " + f.KMSURI + ".")
+	}
+	if len(f.Keyset) != 0 && keyURI != localFileKeyURI {
+		return nil, nil, errors.New("Private keyset " + path + " is stored in cleartext; it is only read with the local-file backend.")
+	}
+	backend, err := BackendForURI(keyURI)
+	if err != nil {
+		return nil, nil, err
+	}
+	masterKey, err := backend.MasterKey(keyURI)
+	if err != nil {
+		return nil, nil, err
+	}
+	kh, err := f.Handle(masterKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	f.KMSURI = keyURI
+	f.masterKey = masterKey
+	return kh, f, nil
+}
+
+// Writes the keyset to the input path in the input format, encrypted with
+// the master key the file was loaded with. An existing file is only replaced
+// if overwrite is true.
+func (f *PrivateKeysetFile) Rewrite(kh *keyset.Handle, format string, path string, overwrite bool) error {
+	if f.masterKey == nil && len(f.Keyset) == 0 {
+		return errors.New("Private keyset file was not loaded with a master key.")
+	}
+	return WritePrivateKeyset(kh, f.masterKey, f.KMSURI, format, path, overwrite)
+}
+
+// Returns true if b only holds characters of the standard base64 alphabet.
+func isBase64(b []byte) bool {
+	return strings.Trim(string(b), "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/=") == ""
+}
@@ -0,0 +1,176 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"encoding/base64"
+	"github.com/google/tink/go/aead"
+	"github.com/google/tink/go/core/registry"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"github.com/google/tink/go/tink"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Creates a cleartext local-kms master keyset and returns its URI and AEAD.
+func newTestMasterKey(t *testing.T, dir string) (string, tink.AEAD) {
+	path := filepath.Join(dir, "master.json")
+	if err := WriteLocalMasterKeyset(path, ""); err != nil {
+		t.Fatalf("Failed to write master keyset: %v", err)
+	}
+	uri := "local-kms://" + path
+	masterKey, err := NewEnvelopeAEAD(NewLocalKMSClient(""), uri)
+	if err != nil {
+		t.Fatalf("Failed to create master key: %v", err)
+	}
+	return uri, masterKey
+}
+
+// Returns the primary key ID of the input handle.
+func primaryKeyID(t *testing.T, kh *keyset.Handle) uint32 {
+	mem := &keyset.MemReaderWriter{}
+	if err := insecurecleartextkeyset.Write(kh, mem); err != nil {
+		t.Fatalf("Failed to export keyset: %v", err)
+	}
+	return mem.Keyset.PrimaryKeyId
+}
+
+func TestPrivateKeysetJSONRoundTrip(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	uri, masterKey := newTestMasterKey(t, dir)
+	kh := newTestKeyset(t)
+	path := filepath.Join(dir, "priv.json")
+	if err := WritePrivateKeyset(kh, masterKey, uri, PrivateKeysetFormatJSON, path, false); err != nil {
+		t.Fatalf("Failed to write private keyset: %v", err)
+	}
+	got, f, err := LoadPrivateKeyset(path, uri)
+	if err != nil {
+		t.Fatalf("Failed to load private keyset: %v", err)
+	}
+	if f.Format != PrivateKeysetFormatJSON || f.KMSURI != uri || f.Created.IsZero() {
+		t.Errorf("Unexpected metadata: %+v", f)
+	}
+	want := primaryKeyID(t, kh)
+	if f.PrimaryKeyID != want || len(f.KeyIDs) != 1 || f.KeyIDs[0] != want {
+		t.Errorf("Metadata key IDs %d, %v; want %d", f.PrimaryKeyID, f.KeyIDs, want)
+	}
+	if primaryKeyID(t, got) != want {
+		t.Errorf("Loaded primary key ID %d; want %d", primaryKeyID(t, got), want)
+	}
+}
+
+func TestPrivateKeysetBinaryRequiresKeyURI(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	uri, masterKey := newTestMasterKey(t, dir)
+	kh := newTestKeyset(t)
+	path := filepath.Join(dir, "priv.bin")
+	if err := WritePrivateKeyset(kh, masterKey, uri, PrivateKeysetFormatBinary, path, false); err != nil {
+		t.Fatalf("Failed to write private keyset: %v", err)
+	}
+	if _, _, err := LoadPrivateKeyset(path, ""); err == nil {
+		t.Errorf("Expected failure loading binary keyset without key URI.")
+	}
+	got, f, err := LoadPrivateKeyset(path, uri)
+	if err != nil {
+		t.Fatalf("Failed to load private keyset: %v", err)
+	}
+	if f.Format != PrivateKeysetFormatBinary || primaryKeyID(t, got) != primaryKeyID(t, kh) {
+		t.Errorf("Unexpected binary keyset: %+v", f)
+	}
+}
+
+func TestPrivateKeysetLegacyMigration(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	uri, _ := newTestMasterKey(t, dir)
+	kh := newTestKeyset(t)
+	// Written the way the old key generators did, through a KMS envelope
+	// keyset with the TINK output prefix of the Tink version they used.
+	registry.RegisterKMSClient(NewLocalKMSClient(""))
+	kt := aead.KMSEnvelopeAEADKeyTemplate(uri, aead.AES128CTRHMACSHA256KeyTemplate())
+	kt.OutputPrefixType = tinkpb.OutputPrefixType_TINK
+	envelopeKh, err := keyset.NewHandle(kt)
+	if err != nil {
+		t.Fatalf("Failed to create envelope keyset: %v", err)
+	}
+	a, err := aead.New(envelopeKh)
+	if err != nil {
+		t.Fatalf("Failed to create envelope AEAD: %v", err)
+	}
+	exported := &keyset.MemReaderWriter{}
+	if err := insecurecleartextkeyset.Write(kh, exported); err != nil {
+		t.Fatalf("Failed to export keyset: %v", err)
+	}
+	ct, err := a.Encrypt([]byte(exported.Keyset.String()), nil)
+	if err != nil {
+		t.Fatalf("Failed to encrypt keyset: %v", err)
+	}
+	legacy := filepath.Join(dir, "priv.legacy")
+	if err := ioutil.WriteFile(legacy, []byte(base64.StdEncoding.EncodeToString(ct)), 0600); err != nil {
+		t.Fatalf("Failed to write legacy keyset: %v", err)
+	}
+	got, f, err := LoadPrivateKeyset(legacy, uri)
+	if err != nil {
+		t.Fatalf("Failed to load legacy keyset: %v", err)
+	}
+	if f.Format != PrivateKeysetFormatLegacy || primaryKeyID(t, got) != primaryKeyID(t, kh) {
+		t.Errorf("Unexpected legacy keyset: %+v", f)
+	}
+	migrated := filepath.Join(dir, "priv.json")
+	if err := f.Rewrite(got, PrivateKeysetFormatJSON, migrated, false); err != nil {
+		t.Fatalf("Failed to migrate keyset: %v", err)
+	}
+	if _, mf, err := LoadPrivateKeyset(migrated, uri); err != nil || mf.Format != PrivateKeysetFormatJSON {
+		t.Errorf("Failed to load migrated keyset: %v", err)
+	}
+}
+
+func TestLoadPrivateKeysetUntrustedURI(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	uri, _ := newTestMasterKey(t, dir)
+	kh := newTestKeyset(t)
+	path := filepath.Join(dir, "priv.json")
+	if err := WritePrivateKeyset(kh, nil, localFileKeyURI, PrivateKeysetFormatJSON, path, false); err != nil {
+		t.Fatalf("Failed to write private keyset: %v", err)
+	}
+	if _, _, err := LoadPrivateKeyset(path, ""); err == nil {
+		t.Errorf("Expected failure loading a keyset without key URI.")
+	}
+	if _, _, err := LoadPrivateKeyset(path, uri); err == nil {
+		t.Errorf("Expected failure loading a keyset with another key URI.")
+	}
+	// A crafted cleartext keyset that does not name its key URI.
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read private keyset: %v", err)
+	}
+	crafted := filepath.Join(dir, "crafted.json")
+	if err := ioutil.WriteFile(crafted, []byte(strings.Replace(string(b), `"kmsUri": "`+localFileKeyURI+`",`, "", 1)), 0600); err != nil {
+		t.Fatalf("Failed to write private keyset: %v", err)
+	}
+	if _, _, err := LoadPrivateKeyset(crafted, uri); err == nil {
+		t.Errorf("Expected failure loading a cleartext keyset with a master key.")
+	}
+	if _, _, err := LoadPrivateKeyset(crafted, localFileKeyURI); err != nil {
+		t.Errorf("Failed to load cleartext keyset with the local-file backend: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"flag"
+	"github.com/google/tink/go/tink"
+)
+
+// URI of the local-file backend, which stores private keysets in cleartext.
+const localFileKeyURI string = "local-file://"
+
+// Stores private keysets without a master key. Only meant for tests and
+// local development.
+type localFileBackend struct{}
+
+func init() {
+	RegisterBackend(&localFileBackend{})
+}
+
+func (b *localFileBackend) Name() string {
+	return "local-file"
+}
+
+func (b *localFileBackend) AddFlags(fs *flag.FlagSet) {}
+
+func (b *localFileBackend) KeyURI() (string, error) {
+	return localFileKeyURI, nil
+}
+
+func (b *localFileBackend) MasterKey(keyURI string) (tink.AEAD, error) {
+	return nil, nil
+}
@@ -0,0 +1,254 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"github.com/google/tink/go/aead"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	"github.com/google/tink/go/tink"
+	"golang.org/x/crypto/scrypt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Prefix of key URIs served by the LocalKMSClient. The rest of the URI is the
+// path of the master keyset file.
+const localKMSPrefix string = "local-kms://"
+
+// Environment variable holding the master keyset passphrase by default.
+const localKMSPassphraseEnv string = "LOCAL_KMS_PASSPHRASE"
+
+// scrypt cost parameters for new passphrase protected master keysets.
+const scryptN int = 1 << 15
+const scryptR int = 8
+const scryptP int = 1
+
+// Size of the scrypt salt of passphrase protected master keysets.
+const scryptSaltSize int = 16
+
+// Largest scrypt cost parameters accepted from master keyset files, so that
+// a crafted file can not make key derivation use more than 32 MiB of memory
+// (128 * N * r bytes) or more CPU than a new keyset.
+const maxScryptN int = 1 << 15
+const maxScryptR int = scryptR
+const maxScryptP int = scryptP
+
+// A passphrase protected master keyset file. The keyset is a Tink
+// EncryptedKeyset in binary format, encrypted with AES-256-GCM under a key
+// derived from the passphrase with scrypt.
+type localMasterKeyFile struct {
+	Salt            []byte `json:"salt"`
+	N               int    `json:"n"`
+	R               int    `json:"r"`
+	P               int    `json:"p"`
+	EncryptedKeyset []byte `json:"encryptedKeyset"`
+}
+
+// A registry.KMSClient whose master keys are AEAD keysets stored in local
+// files, so keys can be generated and unwrapped without a cloud KMS.
+type LocalKMSClient struct {
+	passphrase string
+}
+
+// Public function to create a LocalKMSClient. An empty passphrase reads
+// master keysets stored in cleartext.
+func NewLocalKMSClient(passphrase string) *LocalKMSClient {
+	return &LocalKMSClient{passphrase: passphrase}
+}
+
+// Returns true if the key URI uses the local-kms scheme.
+func (c *LocalKMSClient) Supported(keyURI string) bool {
+	return strings.HasPrefix(keyURI, localKMSPrefix)
+}
+
+// Returns an AEAD backed by the master keyset file named in the key URI.
+func (c *LocalKMSClient) GetAEAD(keyURI string) (tink.AEAD, error) {
+	if !c.Supported(keyURI) {
+		return nil, errors.New("Unsupported key URI: " + keyURI)
+	}
+	kh, err := readLocalMasterKeyset(strings.TrimPrefix(keyURI, localKMSPrefix), c.passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return aead.New(kh)
+}
+
+// Public function to generate a new AES-256-GCM master keyset and write it to
+// the input path. The keyset is protected with the passphrase unless it is
+// empty. Existing files are never overwritten.
+func WriteLocalMasterKeyset(path string, passphrase string) error {
+	kh, err := keyset.NewHandle(aead.AES256GCMKeyTemplate())
+	if err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	if passphrase == "" {
+		if err := insecurecleartextkeyset.Write(kh, keyset.NewJSONWriter(buf)); err != nil {
+			return err
+		}
+	} else {
+		salt := make([]byte, scryptSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		f := localMasterKeyFile{Salt: salt, N: scryptN, R: scryptR, P: scryptP}
+		pa, err := newPassphraseAEAD(passphrase, f)
+		if err != nil {
+			return err
+		}
+		ks := new(bytes.Buffer)
+		if err := kh.Write(keyset.NewBinaryWriter(ks), pa); err != nil {
+			return err
+		}
+		f.EncryptedKeyset = ks.Bytes()
+		b, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	}
+	return WriteKeyFile(path, buf.Bytes(), PrivateKeyFileMode, false)
+}
+
+// Reads a master keyset file, decrypting it with the passphrase if it is
+// passphrase protected.
+func readLocalMasterKeyset(path string, passphrase string) (*keyset.Handle, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f localMasterKeyFile
+	protected := json.Unmarshal(b, &f) == nil && len(f.EncryptedKeyset) > 0
+	if passphrase == "" {
+		if protected {
+			return nil, errors.New("Master keyset " + path + " is passphrase protected.")
+		}
+		return insecurecleartextkeyset.Read(keyset.NewJSONReader(bytes.NewReader(b)))
+	}
+	// A file that does not parse is never read as a cleartext keyset once a
+	// passphrase is configured.
+	if !protected {
+		return nil, errors.New("Master keyset " + path + " is not a passphrase protected keyset.")
+	}
+	if len(f.Salt) != scryptSaltSize {
+		return nil, errors.New("Master keyset " + path + " has an invalid scrypt salt.")
+	}
+	pa, err := newPassphraseAEAD(passphrase, f)
+	if err != nil {
+		return nil, err
+	}
+	kh, err := keyset.Read(keyset.NewBinaryReader(bytes.NewReader(f.EncryptedKeyset)), pa)
+	if err != nil {
+		return nil, errors.New("Could not decrypt master keyset " + path + ", wrong passphrase?")
+	}
+	return kh, nil
+}
+
+// A tink.AEAD using AES-256-GCM with a passphrase derived key. The random
+// nonce is prepended to the ciphertext.
+type passphraseAEAD struct {
+	gcm cipher.AEAD
+}
+
+func newPassphraseAEAD(passphrase string, f localMasterKeyFile) (*passphraseAEAD, error) {
+	if f.N > maxScryptN || f.R > maxScryptR || f.P > maxScryptP {
+		return nil, errors.New("Master keyset scrypt parameters exceed the maximum.")
+	}
+	key, err := scrypt.Key([]byte(passphrase), f.Salt, f.N, f.R, f.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &passphraseAEAD{gcm: gcm}, nil
+}
+
+func (a *passphraseAEAD) Encrypt(plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, a.gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return a.gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (a *passphraseAEAD) Decrypt(ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < a.gcm.NonceSize() {
+		return nil, errors.New("Ciphertext too short.")
+	}
+	n := a.gcm.NonceSize()
+	return a.gcm.Open(nil, ciphertext[:n], ciphertext[n:], additionalData)
+}
+
+// Wraps private keysets with a master keyset stored in a local file.
+type localKMSBackend struct {
+	keysetFile    *string
+	passphraseEnv *string
+	create        *bool
+}
+
+func init() {
+	RegisterBackend(&localKMSBackend{})
+}
+
+func (b *localKMSBackend) Name() string {
+	return "local-kms"
+}
+
+func (b *localKMSBackend) AddFlags(fs *flag.FlagSet) {
+	b.keysetFile = fs.String("local_kms_keyset", "", "Local master keyset file.")
+	b.passphraseEnv = fs.String("local_kms_passphrase_env", localKMSPassphraseEnv, "Environment variable holding the master keyset passphrase. Unset or empty means no passphrase.")
+	b.create = fs.Bool("local_kms_create", false, "Create the local master keyset file before use.")
+}
+
+func (b *localKMSBackend) KeyURI() (string, error) {
+	if b.keysetFile == nil || *b.keysetFile == "" {
+		return "", errors.New("local-kms requires local_kms_keyset.")
+	}
+	return localKMSPrefix + *b.keysetFile, nil
+}
+
+func (b *localKMSBackend) MasterKey(keyURI string) (tink.AEAD, error) {
+	passphrase := b.passphrase()
+	if b.create != nil && *b.create {
+		if err := WriteLocalMasterKeyset(strings.TrimPrefix(keyURI, localKMSPrefix), passphrase); err != nil {
+			return nil, err
+		}
+	}
+	return NewEnvelopeAEAD(NewLocalKMSClient(passphrase), keyURI)
+}
+
+// Returns the passphrase from the configured environment variable.
+func (b *localKMSBackend) passphrase() string {
+	env := localKMSPassphraseEnv
+	if b.passphraseEnv != nil {
+		env = *b.passphraseEnv
+	}
+	return os.Getenv(env)
+}
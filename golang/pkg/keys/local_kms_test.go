@@ -0,0 +1,147 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalKMSClientCleartext(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "master.json")
+	if err := WriteLocalMasterKeyset(path, ""); err != nil {
+		t.Fatalf("Failed to write master keyset: %v", err)
+	}
+	a, err := NewLocalKMSClient("").GetAEAD("local-kms://" + path)
+	if err != nil {
+		t.Fatalf("Failed to get AEAD: %v", err)
+	}
+	ct, err := a.Encrypt([]byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	pt, err := a.Decrypt(ct, nil)
+	if err != nil || !bytes.Equal(pt, []byte("secret")) {
+		t.Errorf("Round trip failed: %q, %v", pt, err)
+	}
+}
+
+func TestLocalKMSClientPassphrase(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "master.json")
+	if err := WriteLocalMasterKeyset(path, "correct horse"); err != nil {
+		t.Fatalf("Failed to write master keyset: %v", err)
+	}
+	uri := "local-kms://" + path
+	a, err := NewEnvelopeAEAD(NewLocalKMSClient("correct horse"), uri)
+	if err != nil {
+		t.Fatalf("Failed to create envelope AEAD: %v", err)
+	}
+	ct, err := a.Encrypt([]byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	pt, err := a.Decrypt(ct, nil)
+	if err != nil || !bytes.Equal(pt, []byte("secret")) {
+		t.Errorf("Round trip failed: %q, %v", pt, err)
+	}
+	if _, err := NewLocalKMSClient("wrong").GetAEAD(uri); err == nil {
+		t.Errorf("Expected failure with wrong passphrase.")
+	}
+	if _, err := NewLocalKMSClient("").GetAEAD(uri); err == nil {
+		t.Errorf("Expected failure without passphrase.")
+	}
+}
+
+func TestLocalKMSClientScryptLimits(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	salt := make([]byte, scryptSaltSize)
+	for i, f := range []localMasterKeyFile{
+		{Salt: salt, N: 1 << 30, R: scryptR, P: scryptP, EncryptedKeyset: []byte("ks")},
+		{Salt: salt, N: 1 << 10, R: 1 << 20, P: scryptP, EncryptedKeyset: []byte("ks")},
+		{Salt: salt, N: 1 << 10, R: scryptR, P: 1 << 20, EncryptedKeyset: []byte("ks")},
+	} {
+		b, err := json.Marshal(f)
+		if err != nil {
+			t.Fatalf("Failed to marshal master keyset file: %v", err)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("master%d.json", i))
+		if err := ioutil.WriteFile(path, b, 0600); err != nil {
+			t.Fatalf("Failed to write master keyset file: %v", err)
+		}
+		if _, err := NewLocalKMSClient("correct horse").GetAEAD("local-kms://" + path); err == nil {
+			t.Errorf("Expected failure on scrypt parameters %d, %d, %d.", f.N, f.R, f.P)
+		}
+	}
+}
+
+func TestLocalKMSClientInvalidProtectedFile(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	cleartext := filepath.Join(dir, "cleartext.json")
+	if err := WriteLocalMasterKeyset(cleartext, ""); err != nil {
+		t.Fatalf("Failed to write master keyset: %v", err)
+	}
+	protected := filepath.Join(dir, "protected.json")
+	if err := WriteLocalMasterKeyset(protected, "correct horse"); err != nil {
+		t.Fatalf("Failed to write master keyset: %v", err)
+	}
+	b, err := ioutil.ReadFile(protected)
+	if err != nil {
+		t.Fatalf("Failed to read master keyset: %v", err)
+	}
+	truncated := filepath.Join(dir, "truncated.json")
+	if err := ioutil.WriteFile(truncated, b[:len(b)/2], 0600); err != nil {
+		t.Fatalf("Failed to write master keyset file: %v", err)
+	}
+	var f localMasterKeyFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		t.Fatalf("Failed to parse master keyset file: %v", err)
+	}
+	f.Salt = f.Salt[:4]
+	b, err = json.Marshal(f)
+	if err != nil {
+		t.Fatalf("Failed to marshal master keyset file: %v", err)
+	}
+	shortSalt := filepath.Join(dir, "salt.json")
+	if err := ioutil.WriteFile(shortSalt, b, 0600); err != nil {
+		t.Fatalf("Failed to write master keyset file: %v", err)
+	}
+	for _, path := range []string{cleartext, truncated, shortSalt} {
+		if _, err := NewLocalKMSClient("correct horse").GetAEAD("local-kms://" + path); err == nil {
+			t.Errorf("%s: expected failure with a passphrase.", filepath.Base(path))
+		}
+	}
+}
+
+func TestWriteLocalMasterKeysetNoOverwrite(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "master.json")
+	if err := WriteLocalMasterKeyset(path, ""); err != nil {
+		t.Fatalf("Failed to write master keyset: %v", err)
+	}
+	if err := WriteLocalMasterKeyset(path, ""); err == nil {
+		t.Errorf("Expected failure overwriting master keyset.")
+	}
+}
@@ -0,0 +1,119 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Helper functions to write key material to disk.
+
+// Permissions of written key files. Private keysets are only readable by
+// their owner, even when they are wrapped by a master key.
+const (
+	PrivateKeyFileMode os.FileMode = 0600
+	PublicKeyFileMode  os.FileMode = 0644
+)
+
+// Public function to check ahead of a run that writes several files that none
+// of the input paths exists, unless overwrite is true. Empty paths are
+// skipped.
+func CheckOverwrite(overwrite bool, paths ...string) error {
+	if overwrite {
+		return nil
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Lstat(path); err == nil {
+			return errors.New("Refusing to overwrite existing file " + path + ". Use --force to replace it.")
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Public function to write key material to the input path. The data is
+// written and synced to a temporary file in the same directory, which is then
+// renamed over the path so readers never see a partial file. An existing file
+// is only replaced if overwrite is true.
+func WriteKeyFile(path string, data []byte, perm os.FileMode, overwrite bool) error {
+	if !overwrite {
+		if _, err := os.Lstat(path); err == nil {
+			return errors.New("Refusing to overwrite existing file " + path + ".")
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if err := writeAndSync(tmp, data, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if overwrite {
+		err = os.Rename(tmpPath, path)
+	} else {
+		// A hard link fails if the path was created since it was checked,
+		// where a rename would silently replace it.
+		if err = os.Link(tmpPath, path); err == nil {
+			err = os.Remove(tmpPath)
+		}
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// Writes data to the open file, sets its permissions and closes it once the
+// contents are on disk.
+func writeAndSync(f *os.File, data []byte, perm os.FileMode) error {
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Syncs a directory so a rename in it survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	// Not all platforms support syncing directories; the rename itself has
+	// already succeeded.
+	d.Sync()
+	return d.Close()
+}
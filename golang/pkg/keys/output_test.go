@@ -0,0 +1,98 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteKeyFile(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "key")
+	if err := WriteKeyFile(path, []byte("first"), PrivateKeyFileMode, false); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	if err := WriteKeyFile(path, []byte("second"), PrivateKeyFileMode, false); err == nil {
+		t.Errorf("Expected failure on existing file.")
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "first" {
+		t.Errorf("Existing file was changed: %q", b)
+	}
+	if err := WriteKeyFile(path, []byte("second"), PrivateKeyFileMode, true); err != nil {
+		t.Fatalf("Failed to overwrite key file: %v", err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "second" {
+		t.Errorf("Expected overwritten contents, got %q", b)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to list dir: %v", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("Expected only the key file, got %d files", len(files))
+	}
+	if mode := files[0].Mode().Perm(); mode != PrivateKeyFileMode {
+		t.Errorf("Expected mode %v, got %v", PrivateKeyFileMode, mode)
+	}
+}
+
+func TestWriteKeysetsNoOverwrite(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	kh := newTestKeyset(t)
+	privPath := filepath.Join(dir, "priv.json")
+	pubPath := filepath.Join(dir, "pub.json")
+	if err := WritePrivateKeyset(kh, nil, localFileKeyURI, PrivateKeysetFormatJSON, privPath, false); err != nil {
+		t.Fatalf("Failed to write private keyset: %v", err)
+	}
+	if err := WritePublicKeyset(kh, pubPath, false); err != nil {
+		t.Fatalf("Failed to write public keyset: %v", err)
+	}
+	other := newTestKeyset(t)
+	if err := WritePrivateKeyset(other, nil, localFileKeyURI, PrivateKeysetFormatJSON, privPath, false); err == nil {
+		t.Errorf("Expected failure on existing private keyset.")
+	}
+	if err := WritePublicKeyset(other, pubPath, false); err == nil {
+		t.Errorf("Expected failure on existing public keyset.")
+	}
+	if err := WritePrivateKeyset(other, nil, localFileKeyURI, PrivateKeysetFormatJSON, privPath, true); err != nil {
+		t.Errorf("Failed to overwrite private keyset: %v", err)
+	}
+	if err := WritePublicKeyset(other, pubPath, true); err != nil {
+		t.Errorf("Failed to overwrite public keyset: %v", err)
+	}
+}
+
+func TestCheckOverwrite(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	existing := filepath.Join(dir, "existing")
+	if err := WriteKeyFile(existing, []byte("key"), PublicKeyFileMode, false); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	missing := filepath.Join(dir, "missing")
+	if err := CheckOverwrite(false, missing, ""); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := CheckOverwrite(false, missing, existing); err == nil {
+		t.Errorf("Expected failure on existing file.")
+	}
+	if err := CheckOverwrite(true, missing, existing); err != nil {
+		t.Errorf("Unexpected error with overwrite: %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/core/registry"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"io/ioutil"
+)
+
+// Helper functions to rotate the keys of a private keyset. Each function
+// returns a new handle and leaves the input handle unchanged.
+
+// Public function to add a new Tink Hybrid key built from the input template
+// to the keyset. The new key is made primary if promote is set, otherwise it
+// is staged for a later PromoteKey. Returns the new handle and the ID of the
+// added key.
+func AddKey(kh *keyset.Handle, kt *tinkpb.KeyTemplate, promote bool) (*keyset.Handle, uint32, error) {
+	ks, err := exportKeyset(kh)
+	if err != nil {
+		return nil, 0, err
+	}
+	keyData, err := registry.NewKeyData(kt)
+	if err != nil {
+		return nil, 0, err
+	}
+	keyID, err := newKeyID(ks)
+	if err != nil {
+		return nil, 0, err
+	}
+	outputPrefixType := kt.OutputPrefixType
+	if outputPrefixType == tinkpb.OutputPrefixType_UNKNOWN_PREFIX {
+		outputPrefixType = tinkpb.OutputPrefixType_TINK
+	}
+	ks.Key = append(ks.Key, &tinkpb.Keyset_Key{
+		KeyData:          keyData,
+		Status:           tinkpb.KeyStatusType_ENABLED,
+		KeyId:            keyID,
+		OutputPrefixType: outputPrefixType,
+	})
+	if promote {
+		ks.PrimaryKeyId = keyID
+	}
+	h, err := importKeyset(ks)
+	if err != nil {
+		return nil, 0, err
+	}
+	return h, keyID, nil
+}
+
+// Public function to check that the public keyset file at path only holds
+// public keys of the private keyset, so a rotation of the keyset may replace
+// it. Returns false if the file can not be read or holds other keys.
+func IsPublicKeysetOf(path string, kh *keyset.Handle) bool {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	ks, err := keyset.NewJSONReader(bytes.NewReader(b)).Read()
+	if err != nil || len(ks.Key) == 0 {
+		return false
+	}
+	pub, err := kh.Public()
+	if err != nil {
+		return false
+	}
+	mem := &keyset.MemReaderWriter{}
+	if err := pub.WriteWithNoSecrets(mem); err != nil {
+		return false
+	}
+	keyData := make(map[uint32]*tinkpb.KeyData)
+	for _, k := range mem.Keyset.Key {
+		keyData[k.KeyId] = k.KeyData
+	}
+	for _, k := range ks.Key {
+		if kd, ok := keyData[k.KeyId]; !ok || !proto.Equal(kd, k.KeyData) {
+			return false
+		}
+	}
+	return true
+}
+
+// Public function to make an enabled key the primary key.
+func PromoteKey(kh *keyset.Handle, keyID uint32) (*keyset.Handle, error) {
+	ks, err := exportKeyset(kh)
+	if err != nil {
+		return nil, err
+	}
+	k, err := findKey(ks, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if k.Status != tinkpb.KeyStatusType_ENABLED {
+		return nil, fmt.Errorf("Key %d is %s and cannot be made primary.", keyID, k.Status)
+	}
+	ks.PrimaryKeyId = keyID
+	return importKeyset(ks)
+}
+
+// Public function to disable a key. Documents encrypted under a disabled key
+// can no longer be decrypted, but the key can be enabled again with
+// EnableKey. The primary key cannot be disabled.
+func DisableKey(kh *keyset.Handle, keyID uint32) (*keyset.Handle, error) {
+	return setKeyStatus(kh, keyID, tinkpb.KeyStatusType_DISABLED)
+}
+
+// Public function to enable a disabled key.
+func EnableKey(kh *keyset.Handle, keyID uint32) (*keyset.Handle, error) {
+	return setKeyStatus(kh, keyID, tinkpb.KeyStatusType_ENABLED)
+}
+
+// Public function to remove a disabled key and its key material from the
+// keyset. This cannot be undone.
+func DestroyKey(kh *keyset.Handle, keyID uint32) (*keyset.Handle, error) {
+	ks, err := exportKeyset(kh)
+	if err != nil {
+		return nil, err
+	}
+	k, err := findKey(ks, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if k.Status != tinkpb.KeyStatusType_DISABLED {
+		return nil, fmt.Errorf("Key %d must be disabled before it is destroyed.", keyID)
+	}
+	var kept []*tinkpb.Keyset_Key
+	for _, k := range ks.Key {
+		if k.KeyId != keyID {
+			kept = append(kept, k)
+		}
+	}
+	ks.Key = kept
+	return importKeyset(ks)
+}
+
+func setKeyStatus(kh *keyset.Handle, keyID uint32, status tinkpb.KeyStatusType) (*keyset.Handle, error) {
+	ks, err := exportKeyset(kh)
+	if err != nil {
+		return nil, err
+	}
+	k, err := findKey(ks, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if keyID == ks.PrimaryKeyId {
+		return nil, fmt.Errorf("Key %d is the primary key.", keyID)
+	}
+	k.Status = status
+	return importKeyset(ks)
+}
+
+func findKey(ks *tinkpb.Keyset, keyID uint32) (*tinkpb.Keyset_Key, error) {
+	for _, k := range ks.Key {
+		if k.KeyId == keyID {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("Key %d not found in keyset.", keyID)
+}
+
+// Returns a random key ID not used by any key in the keyset.
+func newKeyID(ks *tinkpb.Keyset) (uint32, error) {
+	b := make([]byte, 4)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return 0, err
+		}
+		id := binary.BigEndian.Uint32(b)
+		if _, err := findKey(ks, id); id != 0 && err != nil {
+			return id, nil
+		}
+	}
+}
+
+func exportKeyset(kh *keyset.Handle) (*tinkpb.Keyset, error) {
+	mem := &keyset.MemReaderWriter{}
+	if err := insecurecleartextkeyset.Write(kh, mem); err != nil {
+		return nil, err
+	}
+	// Handles share their keyset with the writer, so modify a copy.
+	return proto.Clone(mem.Keyset).(*tinkpb.Keyset), nil
+}
+
+func importKeyset(ks *tinkpb.Keyset) (*keyset.Handle, error) {
+	if err := keyset.Validate(ks); err != nil {
+		return nil, err
+	}
+	return insecurecleartextkeyset.Read(&keyset.MemReaderWriter{Keyset: ks})
+}
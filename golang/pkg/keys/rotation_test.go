@@ -0,0 +1,143 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"github.com/google/tink/go/hybrid"
+	"github.com/google/tink/go/keyset"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"path/filepath"
+	"testing"
+)
+
+func keyStatus(t *testing.T, kh *keyset.Handle, keyID uint32) tinkpb.KeyStatusType {
+	ks, err := exportKeyset(kh)
+	if err != nil {
+		t.Fatalf("Failed to export keyset: %v", err)
+	}
+	k, err := findKey(ks, keyID)
+	if err != nil {
+		return tinkpb.KeyStatusType_UNKNOWN_STATUS
+	}
+	return k.Status
+}
+
+func TestAddKeyStaged(t *testing.T) {
+	kh := newTestKeyset(t)
+	oldID := primaryKeyID(t, kh)
+	staged, newID, err := AddKey(kh, defaultTestTemplate(t), false)
+	if err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+	if primaryKeyID(t, staged) != oldID {
+		t.Errorf("Staged key should not be primary.")
+	}
+	if keyStatus(t, kh, newID) != tinkpb.KeyStatusType_UNKNOWN_STATUS {
+		t.Errorf("Input handle was modified.")
+	}
+	promoted, err := PromoteKey(staged, newID)
+	if err != nil {
+		t.Fatalf("Failed to promote key: %v", err)
+	}
+	if primaryKeyID(t, promoted) != newID {
+		t.Errorf("Primary key %d; want %d", primaryKeyID(t, promoted), newID)
+	}
+}
+
+func TestAddKeyDecryptsOldCiphertexts(t *testing.T) {
+	kh := newTestKeyset(t)
+	pub, err := kh.Public()
+	if err != nil {
+		t.Fatalf("Failed to get public keyset: %v", err)
+	}
+	he, err := hybrid.NewHybridEncrypt(pub)
+	if err != nil {
+		t.Fatalf("Failed to create encrypter: %v", err)
+	}
+	ct, err := he.Encrypt([]byte("document key"), nil)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	rotated, _, err := AddKey(kh, defaultTestTemplate(t), true)
+	if err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+	hd, err := hybrid.NewHybridDecrypt(rotated)
+	if err != nil {
+		t.Fatalf("Failed to create decrypter: %v", err)
+	}
+	if _, err := hd.Decrypt(ct, nil); err != nil {
+		t.Errorf("Failed to decrypt ciphertext of the old key: %v", err)
+	}
+}
+
+func TestDisableAndDestroyKey(t *testing.T) {
+	kh := newTestKeyset(t)
+	oldID := primaryKeyID(t, kh)
+	rotated, _, err := AddKey(kh, defaultTestTemplate(t), true)
+	if err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+	if _, err := DestroyKey(rotated, oldID); err == nil {
+		t.Errorf("Expected failure destroying an enabled key.")
+	}
+	disabled, err := DisableKey(rotated, oldID)
+	if err != nil {
+		t.Fatalf("Failed to disable key: %v", err)
+	}
+	if keyStatus(t, disabled, oldID) != tinkpb.KeyStatusType_DISABLED {
+		t.Errorf("Key %d was not disabled.", oldID)
+	}
+	destroyed, err := DestroyKey(disabled, oldID)
+	if err != nil {
+		t.Fatalf("Failed to destroy key: %v", err)
+	}
+	if keyStatus(t, destroyed, oldID) != tinkpb.KeyStatusType_UNKNOWN_STATUS {
+		t.Errorf("Key %d was not removed.", oldID)
+	}
+}
+
+func TestDisablePrimaryKey(t *testing.T) {
+	kh := newTestKeyset(t)
+	if _, err := DisableKey(kh, primaryKeyID(t, kh)); err == nil {
+		t.Errorf("Expected failure disabling the primary key.")
+	}
+}
+
+func TestIsPublicKeysetOf(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	kh := newTestKeyset(t)
+	path := filepath.Join(dir, "pub.json")
+	if IsPublicKeysetOf(path, kh) {
+		t.Errorf("Missing file should not belong to the keyset.")
+	}
+	if err := WritePublicKeyset(kh, path, false); err != nil {
+		t.Fatalf("Failed to write public keyset: %v", err)
+	}
+	if !IsPublicKeysetOf(path, kh) {
+		t.Errorf("Public keyset should belong to its keyset.")
+	}
+	rotated, _, err := AddKey(kh, defaultTestTemplate(t), false)
+	if err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+	if !IsPublicKeysetOf(path, rotated) {
+		t.Errorf("Public keyset should belong to the rotated keyset.")
+	}
+	if IsPublicKeysetOf(path, newTestKeyset(t)) {
+		t.Errorf("Public keyset should not belong to another keyset.")
+	}
+}
@@ -0,0 +1,164 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/aead"
+	// Registers the ECIES key managers used to generate keys from the templates.
+	_ "github.com/google/tink/go/hybrid"
+	commonpb "github.com/google/tink/go/proto/common_go_proto"
+	eciespb "github.com/google/tink/go/proto/ecies_aead_hkdf_go_proto"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"github.com/google/tink/go/signature"
+	"sort"
+	"strings"
+)
+
+// Helper functions to select the key template of new Tink Hybrid keys.
+
+const eciesPrivateKeyURL string = "type.googleapis.com/google.crypto.tink.EciesAeadHkdfPrivateKey"
+
+// Name of the template used unless another one is asked for. It matches
+// hybrid.ECIESHKDFAES128GCMKeyTemplate.
+const DefaultKeyTemplate string = "ECIES_P256_HKDF_HMAC_SHA256_AES128_GCM"
+
+// Point formats accepted for new keys.
+const (
+	PointFormatUncompressed string = "UNCOMPRESSED"
+	PointFormatCompressed   string = "COMPRESSED"
+)
+
+type eciesTemplate struct {
+	curve commonpb.EllipticCurveType
+	hash  commonpb.HashType
+	dem   func() *tinkpb.KeyTemplate
+}
+
+// Supported ECIES templates by name. Each curve is paired with the HKDF hash
+// of matching strength.
+var keyTemplates = map[string]eciesTemplate{}
+
+func init() {
+	curves := []struct {
+		name  string
+		curve commonpb.EllipticCurveType
+		hash  commonpb.HashType
+	}{
+		{"P256", commonpb.EllipticCurveType_NIST_P256, commonpb.HashType_SHA256},
+		{"P384", commonpb.EllipticCurveType_NIST_P384, commonpb.HashType_SHA384},
+		{"P521", commonpb.EllipticCurveType_NIST_P521, commonpb.HashType_SHA512},
+	}
+	dems := []struct {
+		name string
+		dem  func() *tinkpb.KeyTemplate
+	}{
+		{"AES128_GCM", aead.AES128GCMKeyTemplate},
+		{"AES256_GCM", aead.AES256GCMKeyTemplate},
+		{"AES128_CTR_HMAC_SHA256", aead.AES128CTRHMACSHA256KeyTemplate},
+		{"AES256_CTR_HMAC_SHA256", aead.AES256CTRHMACSHA256KeyTemplate},
+	}
+	for _, c := range curves {
+		for _, d := range dems {
+			name := "ECIES_" + c.name + "_HKDF_HMAC_" + c.hash.String() + "_" + d.name
+			keyTemplates[name] = eciesTemplate{curve: c.curve, hash: c.hash, dem: d.dem}
+		}
+	}
+}
+
+// Public function to list the names of all supported key templates.
+func KeyTemplateNames() []string {
+	var names []string
+	for name := range keyTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Public function to build the named ECIES key template with the input point
+// format. An empty point format means uncompressed points.
+func KeyTemplate(name string, pointFormat string) (*tinkpb.KeyTemplate, error) {
+	t, ok := keyTemplates[name]
+	if !ok {
+		return nil, errors.New("Unknown key template: " + name + ". Supported: " + strings.Join(KeyTemplateNames(), ", "))
+	}
+	var ptfmt commonpb.EcPointFormat
+	switch pointFormat {
+	case "", PointFormatUncompressed:
+		ptfmt = commonpb.EcPointFormat_UNCOMPRESSED
+	case PointFormatCompressed:
+		ptfmt = commonpb.EcPointFormat_COMPRESSED
+	default:
+		return nil, errors.New("Unsupported point format: " + pointFormat + ". Supported: " + PointFormatUncompressed + ", " + PointFormatCompressed)
+	}
+	format := &eciespb.EciesAeadHkdfKeyFormat{
+		Params: &eciespb.EciesAeadHkdfParams{
+			KemParams: &eciespb.EciesHkdfKemParams{
+				CurveType:    t.curve,
+				HkdfHashType: t.hash,
+				HkdfSalt:     []byte{},
+			},
+			DemParams: &eciespb.EciesAeadDemParams{
+				AeadDem: t.dem(),
+			},
+			EcPointFormat: ptfmt,
+		},
+	}
+	serializedFormat, err := proto.Marshal(format)
+	if err != nil {
+		return nil, err
+	}
+	return &tinkpb.KeyTemplate{
+		TypeUrl:          eciesPrivateKeyURL,
+		Value:            serializedFormat,
+		OutputPrefixType: tinkpb.OutputPrefixType_TINK,
+	}, nil
+}
+
+// Helper functions to select the key template of new Tink Signature keys,
+// which sign encrypted documents.
+
+// Templates of the ECDSA keys signing encrypted documents by name. Each
+// curve is paired with the hash of matching strength.
+var signingKeyTemplates = map[string]func() *tinkpb.KeyTemplate{
+	"ECDSA_P256": signature.ECDSAP256KeyTemplate,
+	"ECDSA_P384": signature.ECDSAP384SHA384KeyTemplate,
+	"ECDSA_P521": signature.ECDSAP521KeyTemplate,
+}
+
+// Name of the signing key template used unless another one is asked for.
+const DefaultSigningKeyTemplate string = "ECDSA_P256"
+
+// Public function to list the names of all supported signing key templates.
+func SigningKeyTemplateNames() []string {
+	var names []string
+	for name := range signingKeyTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Public function to build the named ECDSA key template of a document signing
+// key.
+func SigningKeyTemplate(name string) (*tinkpb.KeyTemplate, error) {
+	st, ok := signingKeyTemplates[name]
+	if !ok {
+		return nil, errors.New("Unknown signing key template: " + name + ". Supported: " + strings.Join(SigningKeyTemplateNames(), ", "))
+	}
+	return st(), nil
+}
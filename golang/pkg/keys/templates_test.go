@@ -0,0 +1,120 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keys
+
+import (
+	"bytes"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/hybrid"
+	"github.com/google/tink/go/signature"
+	"testing"
+)
+
+func TestDefaultKeyTemplateMatchesTink(t *testing.T) {
+	kt, err := KeyTemplate(DefaultKeyTemplate, "")
+	if err != nil {
+		t.Fatalf("Failed to build key template: %v", err)
+	}
+	if !proto.Equal(kt, hybrid.ECIESHKDFAES128GCMKeyTemplate()) {
+		t.Errorf("Default template differs from hybrid.ECIESHKDFAES128GCMKeyTemplate.")
+	}
+}
+
+func TestAllKeyTemplatesEncryptAndDecrypt(t *testing.T) {
+	for _, name := range KeyTemplateNames() {
+		for _, pointFormat := range []string{PointFormatUncompressed, PointFormatCompressed} {
+			kt, err := KeyTemplate(name, pointFormat)
+			if err != nil {
+				t.Fatalf("%s/%s: failed to build key template: %v", name, pointFormat, err)
+			}
+			kh, err := GenerateKeyset(kt)
+			if err != nil {
+				t.Fatalf("%s/%s: failed to generate keyset: %v", name, pointFormat, err)
+			}
+			pub, err := kh.Public()
+			if err != nil {
+				t.Fatalf("%s/%s: failed to get public keyset: %v", name, pointFormat, err)
+			}
+			he, err := hybrid.NewHybridEncrypt(pub)
+			if err != nil {
+				t.Fatalf("%s/%s: failed to create encrypter: %v", name, pointFormat, err)
+			}
+			ct, err := he.Encrypt([]byte("document key"), nil)
+			if err != nil {
+				t.Fatalf("%s/%s: failed to encrypt: %v", name, pointFormat, err)
+			}
+			hd, err := hybrid.NewHybridDecrypt(kh)
+			if err != nil {
+				t.Fatalf("%s/%s: failed to create decrypter: %v", name, pointFormat, err)
+			}
+			pt, err := hd.Decrypt(ct, nil)
+			if err != nil || !bytes.Equal(pt, []byte("document key")) {
+				t.Errorf("%s/%s: round trip failed: %v", name, pointFormat, err)
+			}
+		}
+	}
+}
+
+func TestKeyTemplateUnknown(t *testing.T) {
+	if _, err := KeyTemplate("ECIES_P192_AES64", ""); err == nil {
+		t.Errorf("Expected failure on unknown template.")
+	}
+	if _, err := KeyTemplate(DefaultKeyTemplate, "DO_NOT_USE_CRUNCHY_UNCOMPRESSED"); err == nil {
+		t.Errorf("Expected failure on unsupported point format.")
+	}
+}
+
+func TestSigningKeyTemplatesSignAndVerify(t *testing.T) {
+	for _, name := range SigningKeyTemplateNames() {
+		kt, err := SigningKeyTemplate(name)
+		if err != nil {
+			t.Fatalf("%s: failed to build key template: %v", name, err)
+		}
+		kh, err := GenerateKeyset(kt)
+		if err != nil {
+			t.Fatalf("%s: failed to generate keyset: %v", name, err)
+		}
+		pub, err := kh.Public()
+		if err != nil {
+			t.Fatalf("%s: failed to get public keyset: %v", name, err)
+		}
+		signer, err := signature.NewSigner(kh)
+		if err != nil {
+			t.Fatalf("%s: failed to create signer: %v", name, err)
+		}
+		verifier, err := signature.NewVerifier(pub)
+		if err != nil {
+			t.Fatalf("%s: failed to create verifier: %v", name, err)
+		}
+		sig, err := signer.Sign([]byte("digest"))
+		if err != nil {
+			t.Fatalf("%s: failed to sign: %v", name, err)
+		}
+		if err := verifier.Verify(sig, []byte("digest")); err != nil {
+			t.Errorf("%s: failed to verify: %v", name, err)
+		}
+	}
+}
+
+func TestSigningKeyTemplateUnknown(t *testing.T) {
+	if _, err := SigningKeyTemplate(DefaultKeyTemplate); err == nil {
+		t.Errorf("Expected failure on hybrid template.")
+	}
+	for _, name := range SigningKeyTemplateNames() {
+		if _, err := KeyTemplate(name, ""); err == nil {
+			t.Errorf("%s: expected failure on signing template.", name)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keyserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/keyset"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Serves Tink public keysets in the JSON format read by
+// encryption.RetrieveTinkPublicKey.
+
+// How often the keyset files are checked for changes.
+const reloadCheckInterval time.Duration = time.Second
+
+// An http.Handler serving the merged public keysets read from a list of files.
+// The primary key of the first file is the primary key of the served keyset,
+// so a new key can be published next to the current one during a rotation.
+type Handler struct {
+	files     []string
+	maxAge    time.Duration
+	mu        sync.Mutex
+	modTimes  []time.Time
+	lastCheck time.Time
+	body      []byte
+	etag      string
+}
+
+// Public function to create a Handler serving the keysets in the input files.
+// Responses may be cached by clients for maxAge.
+func NewHandler(files []string, maxAge time.Duration) (*Handler, error) {
+	if len(files) == 0 {
+		return nil, errors.New("No public keyset files given.")
+	}
+	h := &Handler{files: files, maxAge: maxAge}
+	if err := h.reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Writes the merged public keyset, or 304 if the client copy is current.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	body, etag := h.current()
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.maxAge.Seconds())))
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(body)
+}
+
+// Returns the served body and its ETag, reloading the files if any of them
+// changed on disk. A failed reload keeps serving the previous keyset.
+func (h *Handler) current() ([]byte, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.lastCheck) >= reloadCheckInterval {
+		h.lastCheck = time.Now()
+		if h.changed() {
+			if err := h.reloadLocked(); err != nil {
+				log.Println("Failed to reload public keysets: ", err)
+			}
+		}
+	}
+	return h.body, h.etag
+}
+
+// Returns true if the modification time of any keyset file changed.
+func (h *Handler) changed() bool {
+	for i, f := range h.files {
+		fi, err := os.Stat(f)
+		if err != nil || !fi.ModTime().Equal(h.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) reload() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastCheck = time.Now()
+	return h.reloadLocked()
+}
+
+// Reads and merges all keyset files. Must be called with h.mu held.
+func (h *Handler) reloadLocked() error {
+	modTimes := make([]time.Time, len(h.files))
+	var keysets []*tinkpb.Keyset
+	for i, f := range h.files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes[i] = fi.ModTime()
+		r, err := os.Open(f)
+		if err != nil {
+			return err
+		}
+		ks, err := keyset.NewJSONReader(r).Read()
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", f, err)
+		}
+		keysets = append(keysets, ks)
+	}
+	merged, err := MergePublicKeysets(keysets)
+	if err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	if err := keyset.NewJSONWriter(buf).Write(merged); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	h.body = buf.Bytes()
+	h.etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	h.modTimes = modTimes
+	return nil
+}
+
+// Public function to merge public keysets into one. The primary key of the
+// first keyset becomes the primary key of the result. Keysets holding secret
+// key material are rejected.
+func MergePublicKeysets(keysets []*tinkpb.Keyset) (*tinkpb.Keyset, error) {
+	if len(keysets) == 0 {
+		return nil, errors.New("No keysets to merge.")
+	}
+	merged := &tinkpb.Keyset{PrimaryKeyId: keysets[0].PrimaryKeyId}
+	seen := make(map[uint32]*tinkpb.Keyset_Key)
+	for _, ks := range keysets {
+		for _, k := range ks.Key {
+			if k.KeyData == nil || k.KeyData.KeyMaterialType != tinkpb.KeyData_ASYMMETRIC_PUBLIC {
+				return nil, fmt.Errorf("Key %d is not a public key.", k.KeyId)
+			}
+			if prev, ok := seen[k.KeyId]; ok {
+				if !proto.Equal(prev, k) {
+					return nil, fmt.Errorf("Conflicting keys with ID %d.", k.KeyId)
+				}
+				continue
+			}
+			seen[k.KeyId] = k
+			merged.Key = append(merged.Key, k)
+		}
+	}
+	if err := keyset.Validate(merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// Returns true if the If-None-Match header value lists the input ETag.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+/* Copyright 2021 The Subscribe with Google Authors. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS-IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package keyserver
+
+import (
+	"bytes"
+	"github.com/google/tink/go/hybrid"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Writes a new public keyset to the input path and returns its primary key ID.
+func writeTestPublicKeyset(t *testing.T, path string) uint32 {
+	kh, err := keyset.NewHandle(hybrid.ECIESHKDFAES128GCMKeyTemplate())
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	pub, err := kh.Public()
+	if err != nil {
+		t.Fatalf("Failed to get public key: %v", err)
+	}
+	exported := &keyset.MemReaderWriter{}
+	if err := pub.WriteWithNoSecrets(exported); err != nil {
+		t.Fatalf("Failed to export public key: %v", err)
+	}
+	buf := new(bytes.Buffer)
+	if err := keyset.NewJSONWriter(buf).Write(exported.Keyset); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write public key file: %v", err)
+	}
+	return exported.Keyset.PrimaryKeyId
+}
+
+func newTestDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "keyserver")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestHandlerServesMergedKeysets(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	current := filepath.Join(dir, "current.json")
+	next := filepath.Join(dir, "next.json")
+	primary := writeTestPublicKeyset(t, current)
+	writeTestPublicKeyset(t, next)
+	h, err := NewHandler([]string{current, next}, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got status %d; want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=3600" {
+		t.Errorf("Got Cache-Control %q", got)
+	}
+	ks, err := keyset.NewJSONReader(rec.Body).Read()
+	if err != nil {
+		t.Fatalf("Failed to read served keyset: %v", err)
+	}
+	if ks.PrimaryKeyId != primary {
+		t.Errorf("Primary key ID %d; want %d", ks.PrimaryKeyId, primary)
+	}
+	if len(ks.Key) != 2 {
+		t.Errorf("Served %d keys; want 2", len(ks.Key))
+	}
+}
+
+func TestHandlerNotModified(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "pub.json")
+	writeTestPublicKeyset(t, path)
+	h, err := NewHandler([]string{path}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("Missing ETag.")
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("Got status %d; want 304", rec.Code)
+	}
+}
+
+func TestHandlerReloadsChangedFile(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "pub.json")
+	writeTestPublicKeyset(t, path)
+	h, err := NewHandler([]string{path}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	primary := writeTestPublicKeyset(t, path)
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("Failed to touch file: %v", err)
+	}
+	h.lastCheck = time.Time{}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	ks, err := keyset.NewJSONReader(rec.Body).Read()
+	if err != nil {
+		t.Fatalf("Failed to read served keyset: %v", err)
+	}
+	if ks.PrimaryKeyId != primary {
+		t.Errorf("Primary key ID %d; want reloaded %d", ks.PrimaryKeyId, primary)
+	}
+}
+
+func TestNewHandlerRejectsPrivateKeyset(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	kh, err := keyset.NewHandle(hybrid.ECIESHKDFAES128GCMKeyTemplate())
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	buf := new(bytes.Buffer)
+	if err := insecurecleartextkeyset.Write(kh, keyset.NewJSONWriter(buf)); err != nil {
+		t.Fatalf("Failed to write private key: %v", err)
+	}
+	path := filepath.Join(dir, "priv.json")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("Failed to write private key file: %v", err)
+	}
+	_, err = NewHandler([]string{path}, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "not a public key") {
+		t.Errorf("Expected failure serving private key material; got %v", err)
+	}
+}